@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/hansels/sense_backend/common/trace"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -63,21 +64,34 @@ func apiError(resp *http.Response, r APIResponse) error {
 	return &err
 }
 
+// Do calls the API within a second. The call is traced as a child of the span in ctx, the request ID and the
+// trace context are passed on in the X-Request-ID and traceparent headers.
 func Do(ctx context.Context, req *http.Request, r APIResponse) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*1)
 	defer cancel()
+
+	ctx, span := trace.Start(ctx, req.Method+" "+req.URL.Host, trace.SpanKindClient)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	defer span.Finish()
+
 	req = req.WithContext(ctx)
+	trace.Inject(ctx, req.Header)
 	resp, err := client.Do(req)
 	if err != nil {
+		span.SetError(err)
 		return err
 	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
 	defer func() {
 		io.CopyN(ioutil.Discard, resp.Body, 512)
 		resp.Body.Close()
 	}()
 
 	if resp.StatusCode != 200 {
-		return apiError(resp, r)
+		err = apiError(resp, r)
+		span.SetError(err)
+		return err
 	}
 
 	return decodeResponse(resp.Body, r)
@@ -1,6 +1,7 @@
 package custerr
 
 import (
+	"errors"
 	"fmt"
 )
 
@@ -9,6 +10,17 @@ type ErrChain struct {
 	Cause   error
 	Fields  map[string]string
 	Type    error
+	// Code is the application error code sent to clients, the default code of Type when empty
+	Code string
+	// Details lists the request fields that failed, sent to clients
+	Details []Detail
+}
+
+// Detail describes why one field of a request was rejected.
+type Detail struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
 }
 
 func (err ErrChain) Error() string {
@@ -23,14 +35,25 @@ func (err ErrChain) Error() string {
 	return fmt.Sprint(err.Message, bcoz, fields)
 }
 
+// Type returns the type of the first ErrChain in the chain of err.
 func Type(err error) error {
-	switch err.(type) {
-	case ErrChain:
-		return err.(ErrChain).Type
+	var chain ErrChain
+	if errors.As(err, &chain) {
+		return chain.Type
 	}
 	return nil
 }
 
+// Unwrap returns the cause, so errors.Is and errors.As look through the chain.
+func (err ErrChain) Unwrap() error {
+	return err.Cause
+}
+
+// Is matches the type of the chain, so errors.Is(err, response.ErrNotFound) holds for a chain of that type.
+func (err ErrChain) Is(target error) bool {
+	return err.Type != nil && errors.Is(err.Type, target)
+}
+
 func (err ErrChain) SetField(key string, value string) ErrChain {
 	if err.Fields == nil {
 		err.Fields = map[string]string{}
@@ -38,3 +61,15 @@ func (err ErrChain) SetField(key string, value string) ErrChain {
 	err.Fields[key] = value
 	return err
 }
+
+func (err ErrChain) SetCode(code string) ErrChain {
+	err.Code = code
+	return err
+}
+
+func (err ErrChain) AddDetail(field string, code string, message string) ErrChain {
+	// Copy on append so chains derived from the same error never share details
+	details := err.Details[:len(err.Details):len(err.Details)]
+	err.Details = append(details, Detail{Field: field, Code: code, Message: message})
+	return err
+}
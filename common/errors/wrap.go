@@ -0,0 +1,22 @@
+package errors
+
+import (
+	std_errors "errors"
+)
+
+func (err *stack) Unwrap() error { return err.cause }
+
+// Is reports whether any error in the chain of err matches target, see the standard errors.Is.
+func Is(err, target error) bool {
+	return std_errors.Is(err, target)
+}
+
+// As finds the first error in the chain of err that matches target, see the standard errors.As.
+func As(err error, target interface{}) bool {
+	return std_errors.As(err, target)
+}
+
+// Unwrap returns the error wrapped by err, or nil.
+func Unwrap(err error) error {
+	return std_errors.Unwrap(err)
+}
@@ -1,19 +1,16 @@
 package log
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"github.com/julienschmidt/httprouter"
+	"github.com/hansels/sense_backend/common/trace"
 	"github.com/sirupsen/logrus"
-	"net/http"
 	"os"
 	"runtime"
 	"strings"
-	"time"
 )
 
-const logFormat = `date=%s, method=%s, url=%s,  response_time=%s`
-
 var logLevel = flag.String("log_level", "info", "set log level")
 var errorLogPath = flag.String("error_log", "logs/error.log", "log path")
 
@@ -68,14 +65,6 @@ func GetLevel() string {
 	return strings.ToUpper(logrus.GetLevel().String())
 }
 
-func Request(next httprouter.Handle) httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		t := time.Now()
-		next(w, r, ps)
-		Infof(logFormat, t, r.Method, r.RequestURI, time.Since(t))
-	}
-}
-
 func Info(args ...interface{}) {
 	var function string
 	pc, file, line, ok := runtime.Caller(1)
@@ -350,3 +339,28 @@ func WithFields(fields Fields) *logrus.Entry {
 
 	return logrus.WithFields(logrusFields)
 }
+
+// WithContext returns an entry carrying the request ID and the trace of ctx, so the lines logged for one
+// request can be found together.
+func WithContext(ctx context.Context) *logrus.Entry {
+	var function string
+	pc, file, line, ok := runtime.Caller(1)
+	if !ok {
+		file = "<???>"
+		line = 1
+	} else {
+		slash := strings.LastIndex(file, "/")
+		file = file[slash+1:]
+		function = runtime.FuncForPC(pc).Name()[strings.LastIndex(runtime.FuncForPC(pc).Name(), "/")+1:]
+	}
+
+	fields := logrus.Fields{"source": fmt.Sprintf("%s:%d %s", file, line, function)}
+	if requestID := trace.RequestID(ctx); requestID != "" {
+		fields["request_id"] = requestID
+	}
+	if sc := trace.FromContext(ctx); sc.IsValid() {
+		fields["trace_id"] = sc.TraceID.String()
+		fields["span_id"] = sc.SpanID.String()
+	}
+	return logrus.WithFields(fields)
+}
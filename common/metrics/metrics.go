@@ -0,0 +1,318 @@
+// Package metrics keeps counters, gauges and histograms and serves them in the Prometheus text format
+// (https://prometheus.io/docs/instrumenting/exposition_formats/). Metrics register themselves when created and
+// are exposed by Handler together with the Go runtime stats.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the upper bounds, in seconds, of histograms timing requests.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]collector{}
+)
+
+// register adds a metric to what Handler exposes. A name can only be taken once.
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[c.name()]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", c.name()))
+	}
+	registry[c.name()] = c
+}
+
+// WriteAll writes every registered metric, ordered by name.
+func WriteAll(w io.Writer) {
+	registryMu.RLock()
+	collectors := make([]collector, 0, len(registry))
+	for _, c := range registry {
+		collectors = append(collectors, c)
+	}
+	registryMu.RUnlock()
+
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the registered metrics to Prometheus.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		WriteAll(w)
+	})
+}
+
+// desc is what every metric has, its labels are given by value in the order they were declared.
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (d desc) name() string {
+	return d.metricName
+}
+
+func (d desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, escapeHelp(d.help), d.metricName, d.kind)
+}
+
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.metricName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats the labels of a series, extra is appended as is, e.g. le="0.5".
+func (d desc) labelPairs(values []string, extra string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escapeLabel(value)+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// values is a set of series by label key, kept in insertion order for a stable output.
+type values struct {
+	mu          sync.Mutex
+	keys        []string
+	labelValues map[string][]string
+	series      map[string]interface{}
+}
+
+func (v *values) get(key string, labels []string, create func() interface{}) interface{} {
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+	if v.series == nil {
+		v.series = map[string]interface{}{}
+		v.labelValues = map[string][]string{}
+	}
+
+	s := create()
+	v.keys = append(v.keys, key)
+	v.series[key] = s
+	v.labelValues[key] = append([]string(nil), labels...)
+	return s
+}
+
+// Counter is a value that only goes up, e.g. the requests served.
+type Counter struct {
+	desc
+	values
+}
+
+// NewCounter registers a counter. Its series are told apart by the values of labels.
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{metricName: name, help: help, kind: "counter", labels: labels}}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", c.metricName))
+	}
+	key := c.key(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := c.get(key, labelValues, func() interface{} { return new(float64) }).(*float64)
+	*v += delta
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.header(w)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range c.keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labelPairs(c.labelValues[key], ""), formatFloat(*c.series[key].(*float64)))
+	}
+}
+
+// Gauge is a value that goes up and down, e.g. the requests in flight.
+type Gauge struct {
+	desc
+	values
+}
+
+// NewGauge registers a gauge. Its series are told apart by the values of labels.
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{metricName: name, help: help, kind: "gauge", labels: labels}}
+	register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.update(func(v *float64) { *v = value }, labelValues)
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.update(func(v *float64) { *v += delta }, labelValues)
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *Gauge) update(f func(v *float64), labelValues []string) {
+	key := g.key(labelValues)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f(g.get(key, labelValues, func() interface{} { return new(float64) }).(*float64))
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.header(w)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range g.keys {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, g.labelPairs(g.labelValues[key], ""), formatFloat(*g.series[key].(*float64)))
+	}
+}
+
+// GaugeFunc is a gauge read when the metrics are scraped, e.g. the length of a queue.
+type GaugeFunc struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is f, it must be safe to call concurrently.
+func NewGaugeFunc(name string, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{metricName: name, help: help, kind: "gauge"}, f: f}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.f()))
+}
+
+// Histogram counts observations, e.g. latencies, in buckets of upper bounds.
+type Histogram struct {
+	desc
+	values
+	buckets []float64
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given upper bounds, DefBuckets when nil. Its series are told apart
+// by the values of labels.
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{desc: desc{metricName: name, help: help, kind: "histogram", labels: labels}, buckets: buckets}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(key, labelValues, func() interface{} {
+		return &histogramSeries{counts: make([]uint64, len(h.buckets))}
+	}).(*histogramSeries)
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.header(w)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range h.keys {
+		s := h.series[key].(*histogramSeries)
+		labels := h.labelValues[key]
+		for i, bound := range h.buckets {
+			le := `le="` + formatFloat(bound) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelPairs(labels, le), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelPairs(labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labelPairs(labels, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labelPairs(labels, ""), s.count)
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
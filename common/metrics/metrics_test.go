@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// unregister drops the metrics of a test once it is done, so the tests can run more than once.
+func unregister(t *testing.T, names ...string) {
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		for _, name := range names {
+			delete(registry, name)
+		}
+	})
+}
+
+func written(c collector) string {
+	var buf bytes.Buffer
+	c.write(&buf)
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	unregister(t, "test_counter_total")
+	c := NewCounter("test_counter_total", "Requests\nby \\ path.", "path", "code")
+	c.Inc("/a", "200")
+	c.Add(2.5, "/a", "200")
+	c.Inc(`/b"quoted"`, "500")
+	c.Inc("back\\slash\nline", "404")
+
+	want := `# HELP test_counter_total Requests\nby \\ path.
+# TYPE test_counter_total counter
+test_counter_total{path="/a",code="200"} 3.5
+test_counter_total{path="/b\"quoted\"",code="500"} 1
+test_counter_total{path="back\\slash\nline",code="404"} 1
+`
+	if got := written(c); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCounterPanics(t *testing.T) {
+	unregister(t, "test_counter_panics_total")
+	c := NewCounter("test_counter_panics_total", "Panics.", "label")
+
+	for name, f := range map[string]func(){
+		"decrease":         func() { c.Add(-1, "x") },
+		"missing label":    func() { c.Inc() },
+		"too many labels":  func() { c.Inc("x", "y") },
+		"registered twice": func() { NewCounter("test_counter_panics_total", "Again.") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestGauge(t *testing.T) {
+	unregister(t, "test_gauge")
+	g := NewGauge("test_gauge", "In flight.", "queue")
+	g.Inc("a")
+	g.Inc("a")
+	g.Dec("a")
+	g.Set(-4, "b")
+	g.Add(0.25, "b")
+
+	want := `# HELP test_gauge In flight.
+# TYPE test_gauge gauge
+test_gauge{queue="a"} 1
+test_gauge{queue="b"} -3.75
+`
+	if got := written(g); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeWithoutLabels(t *testing.T) {
+	unregister(t, "test_gauge_plain")
+	g := NewGauge("test_gauge_plain", "Plain.")
+	g.Set(7)
+
+	want := "# HELP test_gauge_plain Plain.\n# TYPE test_gauge_plain gauge\ntest_gauge_plain 7\n"
+	if got := written(g); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	unregister(t, "test_gauge_func")
+	value := 3.0
+	g := NewGaugeFunc("test_gauge_func", "Queued.", func() float64 { return value })
+	value = 5
+
+	want := "# HELP test_gauge_func Queued.\n# TYPE test_gauge_func gauge\ntest_gauge_func 5\n"
+	if got := written(g); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	unregister(t, "test_duration_seconds")
+	// Bounds are sorted whatever order they are given in
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{1, 0.1, 0.5}, "route")
+	h.Observe(0.05, `/x"y`)
+	h.Observe(0.1, `/x"y`)
+	h.Observe(0.7, `/x"y`)
+	h.Observe(3, `/x"y`)
+
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/x\"y",le="0.1"} 2
+test_duration_seconds_bucket{route="/x\"y",le="0.5"} 2
+test_duration_seconds_bucket{route="/x\"y",le="1"} 3
+test_duration_seconds_bucket{route="/x\"y",le="+Inf"} 4
+test_duration_seconds_sum{route="/x\"y"} 3.85
+test_duration_seconds_count{route="/x\"y"} 4
+`
+	if got := written(h); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramDefaultBuckets(t *testing.T) {
+	unregister(t, "test_default_buckets_seconds")
+	h := NewHistogram("test_default_buckets_seconds", "Defaults.", nil)
+	h.Observe(0.2)
+
+	got := written(h)
+	if n := strings.Count(got, "test_default_buckets_seconds_bucket{"); n != len(DefBuckets)+1 {
+		t.Errorf("got %d buckets, want %d:\n%s", n, len(DefBuckets)+1, got)
+	}
+	if !strings.Contains(got, `test_default_buckets_seconds_bucket{le="0.1"} 0`) ||
+		!strings.Contains(got, `test_default_buckets_seconds_bucket{le="0.25"} 1`) {
+		t.Errorf("wrong bucket counts:\n%s", got)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := map[float64]string{0: "0", 1.5: "1.5", 1e21: "1e+21", -2: "-2"}
+	for value, want := range tests {
+		if got := formatFloat(value); got != want {
+			t.Errorf("formatFloat(%v) = %s, want %s", value, got, want)
+		}
+	}
+}
+
+// sample is a line of the text format: a name, optional labels and a value.
+var sample = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{([a-zA-Z_][a-zA-Z0-9_]*="([^"\\\n]|\\.)*",?)*\})? \S+$`)
+
+func TestHandler(t *testing.T) {
+	unregister(t, "test_handler_total")
+	NewCounter("test_handler_total", "Handled.").Inc()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("content type is %q", got)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{"test_handler_total 1\n", "# TYPE go_goroutines gauge\n", "go_info{version=\"go", "process_start_time_seconds "} {
+		if !strings.Contains(body, want) {
+			t.Errorf("output has no %q", want)
+		}
+	}
+
+	// Every metric is written once, in name order, and every sample parses
+	var names []string
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			names = append(names, strings.Fields(line)[2])
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if !sample.MatchString(line) {
+			t.Errorf("malformed sample %q", line)
+		}
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			t.Errorf("%s is written twice", name)
+		}
+		seen[name] = true
+	}
+	var ours []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "test_") {
+			ours = append(ours, name)
+		}
+	}
+	if !sort.StringsAreSorted(ours) {
+		t.Errorf("metrics are not ordered by name: %v", ours)
+	}
+}
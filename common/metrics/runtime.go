@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+var startTime = time.Now()
+
+func init() {
+	register(runtimeCollector{})
+}
+
+// runtimeCollector exposes the Go runtime stats under the names the official Go client uses, so existing
+// dashboards work.
+type runtimeCollector struct{}
+
+func (runtimeCollector) name() string {
+	return "go_"
+}
+
+func (runtimeCollector) write(w io.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	gauge := func(name string, help string, value float64) {
+		desc{metricName: name, help: help, kind: "gauge"}.header(w)
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+	}
+	counter := func(name string, help string, value float64) {
+		desc{metricName: name, help: help, kind: "counter"}.header(w)
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+	}
+
+	gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	threads, _ := runtime.ThreadCreateProfile(nil)
+	gauge("go_threads", "Number of OS threads created.", float64(threads))
+
+	desc{metricName: "go_info", help: "Information about the Go environment.", kind: "gauge"}.header(w)
+	fmt.Fprintf(w, "go_info{version=\"%s\"} 1\n", escapeLabel(runtime.Version()))
+
+	gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(stats.Alloc))
+	counter("go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(stats.TotalAlloc))
+	gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(stats.Sys))
+	counter("go_memstats_mallocs_total", "Total number of mallocs.", float64(stats.Mallocs))
+	counter("go_memstats_frees_total", "Total number of frees.", float64(stats.Frees))
+	gauge("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(stats.HeapAlloc))
+	gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(stats.HeapInuse))
+	gauge("go_memstats_heap_idle_bytes", "Number of heap bytes waiting to be used.", float64(stats.HeapIdle))
+	gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(stats.HeapObjects))
+	gauge("go_memstats_stack_inuse_bytes", "Number of bytes in use by the stack allocator.", float64(stats.StackInuse))
+	gauge("go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", float64(stats.NextGC))
+	gauge("go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.", float64(stats.LastGC)/1e9)
+	counter("go_gc_cycles_total", "Number of completed GC cycles.", float64(stats.NumGC))
+	counter("go_gc_pause_seconds_total", "Total time the world was stopped for garbage collection.", float64(stats.PauseTotalNs)/1e9)
+
+	gauge("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", float64(startTime.UnixNano())/1e9)
+}
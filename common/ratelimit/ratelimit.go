@@ -0,0 +1,102 @@
+// Package ratelimit provides in-memory token buckets keyed by client.
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// maxKeys bounds the buckets a limiter keeps, so clients rotating keys cannot grow it without limit.
+const maxKeys = 10000
+
+// Limiter gives every key a bucket of burst tokens that refills at rate tokens per second. It keeps the buckets of
+// at most maxKeys keys, the least recently used bucket is dropped to make room for a new key.
+type Limiter struct {
+	rate    float64
+	burst   int
+	maxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// recent orders the buckets from the most to the least recently used
+	recent *list.List
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Result describes the bucket of a key after a request was taken from it.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next token, zero when the request was allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// New returns a limiter allowing requests per period on average with bursts of up to burst requests.
+func New(requests int, per time.Duration, burst int) *Limiter {
+	return &Limiter{
+		rate:    float64(requests) / per.Seconds(),
+		burst:   burst,
+		maxKeys: maxKeys,
+		buckets: map[string]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+// Allow takes a token from the bucket of key if there is one.
+func (l *Limiter) Allow(key string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var b *bucket
+	if e, ok := l.buckets[key]; ok {
+		l.recent.MoveToFront(e)
+		b = e.Value.(*bucket)
+	} else {
+		l.evict(now)
+		b = &bucket{key: key, tokens: float64(l.burst), last: now}
+		l.buckets[key] = l.recent.PushFront(b)
+	}
+
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	result := Result{Limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = l.duration(1 - b.tokens)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = l.duration(float64(l.burst) - b.tokens)
+	return result
+}
+
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// evict makes room for a new bucket. Buckets at the old end that have refilled completely are the same as new ones
+// and always go; when the limiter is still full the least recently used bucket goes too, its key starts over
+// with a full bucket. Only the old end is looked at, so a request never pays for a scan of all keys.
+func (l *Limiter) evict(now time.Time) {
+	for e := l.recent.Back(); e != nil; e = l.recent.Back() {
+		b := e.Value.(*bucket)
+		if len(l.buckets) < l.maxKeys && b.tokens+now.Sub(b.last).Seconds()*l.rate < float64(l.burst) {
+			return
+		}
+		l.recent.Remove(e)
+		delete(l.buckets, b.key)
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	l := New(1, time.Hour, 3)
+
+	for i := 0; i < 3; i++ {
+		result := l.Allow("client")
+		if !result.Allowed || result.Limit != 3 || result.Remaining != 2-i || result.RetryAfter != 0 {
+			t.Fatalf("request %d got %+v", i+1, result)
+		}
+	}
+
+	result := l.Allow("client")
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("request over the burst got %+v", result)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Hour || result.Reset < 2*time.Hour {
+		t.Errorf("retry after %s, reset after %s", result.RetryAfter, result.Reset)
+	}
+
+	if result := l.Allow("other"); !result.Allowed {
+		t.Errorf("other client got %+v", result)
+	}
+}
+
+func TestAllowRefills(t *testing.T) {
+	l := New(1000, time.Second, 1)
+
+	if !l.Allow("client").Allowed {
+		t.Fatal("first request denied")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if result := l.Allow("client"); !result.Allowed {
+		t.Errorf("request after the refill got %+v", result)
+	}
+}
+
+func TestRotatingKeysAreBounded(t *testing.T) {
+	l := New(1, time.Hour, 2)
+	l.maxKeys = 100
+
+	for i := 0; i < 10*l.maxKeys; i++ {
+		l.Allow("client-" + strconv.Itoa(i))
+		if n := len(l.buckets); n > l.maxKeys {
+			t.Fatalf("%d keys kept after %d requests", n, i+1)
+		}
+	}
+	if len(l.buckets) != l.recent.Len() {
+		t.Errorf("%d buckets but %d in the recent list", len(l.buckets), l.recent.Len())
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	l := New(1, time.Hour, 1)
+	l.maxKeys = 3
+
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("c")
+	// a is used again, so b is the least recently used when d comes
+	l.Allow("a")
+	l.Allow("d")
+
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("least recently used key kept")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := l.buckets[key]; !ok {
+			t.Errorf("%s evicted", key)
+		}
+	}
+	// The state of the keys kept is untouched
+	if result := l.Allow("a"); result.Allowed {
+		t.Errorf("a got a new bucket: %+v", result)
+	}
+}
+
+func TestEvictsRefilledBucketsFirst(t *testing.T) {
+	l := New(1000, time.Second, 1)
+	l.maxKeys = 10
+
+	for i := 0; i < 5; i++ {
+		l.Allow("old-" + strconv.Itoa(i))
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// The old buckets are full again and go as soon as a new key arrives, before the limiter is full
+	l.Allow("new")
+	if n := len(l.buckets); n != 1 {
+		t.Errorf("%d keys kept, want only the new one", n)
+	}
+}
+
+func BenchmarkAllowRotatingKeys(b *testing.B) {
+	l := New(1, time.Hour, 1)
+	for i := 0; i < b.N; i++ {
+		l.Allow(strconv.Itoa(i))
+	}
+}
@@ -0,0 +1,160 @@
+// Package reporter reports server errors and panics, with their stack, as one structured log event and
+// optionally forwards them to a Sentry-compatible endpoint.
+package reporter
+
+import (
+	"fmt"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/errors"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is one reported error.
+type Event struct {
+	Message   string
+	Panic     bool
+	Status    int
+	Method    string
+	Path      string
+	Route     string
+	UserID    string
+	RequestID string
+	TraceID   string
+	Fields    map[string]interface{}
+	Stack     []Frame
+	Time      time.Time
+}
+
+// Frame is one call of a stack, innermost first.
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// Forwarder sends events somewhere besides the log, e.g. Sentry.
+type Forwarder interface {
+	Forward(event Event) error
+}
+
+var (
+	mu        sync.RWMutex
+	forwarder Forwarder
+)
+
+// SetForwarder installs the forwarder events are sent to, nil only logs them.
+func SetForwarder(f Forwarder) {
+	mu.Lock()
+	defer mu.Unlock()
+	forwarder = f
+}
+
+// Report logs the event and forwards it in the background.
+func Report(event Event) {
+	log.WithFields(log.Fields{
+		"panic":      event.Panic,
+		"status":     event.Status,
+		"method":     event.Method,
+		"path":       event.Path,
+		"route":      event.Route,
+		"user_id":    event.UserID,
+		"request_id": event.RequestID,
+		"trace_id":   event.TraceID,
+		"fields":     event.Fields,
+		"stack":      formatStack(event.Stack),
+	}).Error(event.Message)
+
+	mu.RLock()
+	f := forwarder
+	mu.RUnlock()
+	if f == nil {
+		return
+	}
+
+	go func() {
+		if err := f.Forward(event); err != nil {
+			log.Warnf("Forward error report failed : %+v", err)
+		}
+	}()
+}
+
+// ReportResponse reports an error response of a request, panics included. Only server errors are reported.
+func ReportResponse(r *http.Request, resp *response.JSONResponse, panicked bool) {
+	if resp == nil || resp.StatusCode < http.StatusInternalServerError {
+		return
+	}
+
+	err := resp.Cause
+	if err == nil {
+		err = resp.Error
+	}
+
+	Report(Event{
+		Message:   message(err),
+		Panic:     panicked,
+		Status:    resp.StatusCode,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Route:     r.Header.Get("routePath"),
+		UserID:    r.Header.Get("UserID"),
+		RequestID: resp.RequestID,
+		TraceID:   resp.TraceID,
+		Fields:    resp.Log,
+		Stack:     Stack(err),
+		Time:      time.Now(),
+	})
+}
+
+// Stack returns the innermost stack attached to err by common/errors, following wrapped errors.
+func Stack(err error) []Frame {
+	var frames *runtime.Frames
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if fss := errors.StackFrames(e); len(fss) > 0 {
+			frames = fss[0]
+		}
+	}
+	if frames == nil {
+		return nil
+	}
+
+	var stack []Frame
+	for more := true; more; {
+		var f runtime.Frame
+		f, more = frames.Next()
+		if f.Function == "" {
+			continue
+		}
+		stack = append(stack, Frame{Function: f.Function, File: f.File, Line: f.Line})
+	}
+	return stack
+}
+
+// message is the error without the stack common/errors puts in its text.
+func message(err error) string {
+	if err == nil {
+		return "Unknown error"
+	}
+	if chain, ok := err.(custerr.ErrChain); ok {
+		return chain.Error()
+	}
+	for cause := errors.Cause(err); cause != nil; cause = errors.Cause(err) {
+		err = cause
+	}
+	return err.Error()
+}
+
+func formatStack(stack []Frame) string {
+	var b strings.Builder
+	for _, f := range stack {
+		_, file := filepath.Split(f.File)
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", f.Function, file, f.Line)
+	}
+	return b.String()
+}
@@ -0,0 +1,151 @@
+package reporter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const sentryTimeout = 5 * time.Second
+
+// Sentry forwards events to the store endpoint of a Sentry-compatible server.
+type Sentry struct {
+	endpoint string
+	auth     string
+	client   *http.Client
+}
+
+// NewSentry parses a DSN of the form scheme://public_key@host/project_id.
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("sentry dsn has no public key")
+	}
+
+	path := strings.Trim(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	project := path[slash+1:]
+	if project == "" {
+		return nil, fmt.Errorf("sentry dsn has no project id")
+	}
+	prefix := ""
+	if slash >= 0 {
+		prefix = "/" + path[:slash]
+	}
+
+	return &Sentry{
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=sense/1.0, sentry_key=%s", u.User.Username()),
+		client:   &http.Client{Timeout: sentryTimeout},
+	}, nil
+}
+
+type sentryEvent struct {
+	EventID   string                 `json:"event_id"`
+	Timestamp string                 `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Platform  string                 `json:"platform"`
+	Message   string                 `json:"message"`
+	Tags      map[string]string      `json:"tags"`
+	Extra     map[string]interface{} `json:"extra,omitempty"`
+	User      map[string]string      `json:"user,omitempty"`
+	Request   map[string]string      `json:"request"`
+	Exception map[string]interface{} `json:"exception"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+func (s *Sentry) Forward(event Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	// Sentry wants the innermost frame last
+	frames := make([]sentryFrame, 0, len(event.Stack))
+	for i := len(event.Stack) - 1; i >= 0; i-- {
+		f := event.Stack[i]
+		frames = append(frames, sentryFrame{Function: f.Function, Filename: f.File, Lineno: f.Line})
+	}
+
+	errorType := "error"
+	level := "error"
+	if event.Panic {
+		errorType = "panic"
+		level = "fatal"
+	}
+
+	payload := sentryEvent{
+		EventID:   hex.EncodeToString(id),
+		Timestamp: event.Time.UTC().Format("2006-01-02T15:04:05"),
+		Level:     level,
+		Platform:  "go",
+		Message:   event.Message,
+		Tags: map[string]string{
+			"route":      event.Route,
+			"status":     fmt.Sprint(event.Status),
+			"request_id": event.RequestID,
+			"trace_id":   event.TraceID,
+		},
+		Extra:   stringify(event.Fields),
+		Request: map[string]string{"method": event.Method, "url": event.Path},
+		Exception: map[string]interface{}{
+			"values": []interface{}{map[string]interface{}{
+				"type":       errorType,
+				"value":      event.Message,
+				"stacktrace": map[string]interface{}{"frames": frames},
+			}},
+		},
+	}
+	if event.UserID != "" {
+		payload.User = map[string]string{"id": event.UserID}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("sentry responded %s", resp.Status)
+	}
+	return nil
+}
+
+// stringify makes error values readable, they marshal to {} otherwise.
+func stringify(fields map[string]interface{}) map[string]interface{} {
+	extra := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if err, ok := value.(error); ok {
+			value = message(err)
+		}
+		extra[key] = value
+	}
+	return extra
+}
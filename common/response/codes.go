@@ -0,0 +1,94 @@
+package response
+
+import (
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/errors"
+	"strings"
+)
+
+// Application error codes tell clients apart errors that share an HTTP status.
+const (
+	CodeBadRequest         = "BAD_REQUEST"
+	CodeValidationFailed   = "VALIDATION_FAILED"
+	CodeForbidden          = "FORBIDDEN"
+	CodeNotFound           = "NOT_FOUND"
+	CodeTimeout            = "TIMEOUT"
+	CodeUserAlreadyExists  = "USER_ALREADY_EXISTS"
+	CodeInvalidCredentials = "INVALID_CREDENTIALS"
+	CodeInternal           = "INTERNAL_ERROR"
+	CodeServiceUnavailable = "SERVICE_UNAVAILABLE"
+	CodeTooManyRequests    = "TOO_MANY_REQUESTS"
+	CodeInvalidImage       = "INVALID_IMAGE"
+	CodeImageTooLarge      = "IMAGE_TOO_LARGE"
+	CodeInvalidTwoFactor   = "INVALID_TWO_FACTOR_CODE"
+)
+
+// Detail codes say what is wrong with a single field.
+const (
+	DetailRequired = "REQUIRED"
+	DetailInvalid  = "INVALID"
+	DetailUnknown  = "UNKNOWN"
+	DetailTooLarge = "TOO_LARGE"
+)
+
+// messages are the client messages of the application codes by language, used when a handler gave no message.
+var messages = map[string]map[string]string{
+	"en": {
+		CodeBadRequest:         "Bad Request",
+		CodeValidationFailed:   "Some Fields Are Invalid!",
+		CodeForbidden:          "Unauthorized Access!",
+		CodeNotFound:           "Not Found",
+		CodeTimeout:            "Request Timeout",
+		CodeUserAlreadyExists:  "User Already Registered!",
+		CodeInvalidCredentials: "Login Unsuccessful!",
+		CodeInternal:           "Internal Server Error",
+		CodeServiceUnavailable: "Service Unavailable, Try Again Later!",
+		CodeTooManyRequests:    "Too Many Requests, Try Again Later!",
+		CodeInvalidImage:       "Invalid Image!",
+		CodeImageTooLarge:      "Image Too Large!",
+		CodeInvalidTwoFactor:   "Invalid Code!",
+	},
+	"id": {
+		CodeBadRequest:         "Permintaan Tidak Valid",
+		CodeValidationFailed:   "Beberapa Isian Tidak Valid!",
+		CodeForbidden:          "Akses Tidak Diizinkan!",
+		CodeNotFound:           "Tidak Ditemukan",
+		CodeTimeout:            "Waktu Permintaan Habis",
+		CodeUserAlreadyExists:  "Pengguna Sudah Terdaftar!",
+		CodeInvalidCredentials: "Login Gagal!",
+		CodeInternal:           "Terjadi Kesalahan Pada Server",
+		CodeServiceUnavailable: "Layanan Tidak Tersedia, Coba Lagi Nanti!",
+		CodeTooManyRequests:    "Terlalu Banyak Permintaan, Coba Lagi Nanti!",
+		CodeInvalidImage:       "Gambar Tidak Valid!",
+		CodeImageTooLarge:      "Ukuran Gambar Terlalu Besar!",
+		CodeInvalidTwoFactor:   "Kode Tidak Valid!",
+	},
+}
+
+const defaultLanguage = "en"
+
+// ErrorCode returns the application code of an error. Going from the outside in, the first custerr.ErrChain with
+// a code or the first registered sentinel decides.
+func ErrorCode(err error) string {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if chain, ok := e.(custerr.ErrChain); ok && chain.Code != "" {
+			return chain.Code
+		}
+		if reg, ok := lookupOne(e); ok {
+			return reg.code
+		}
+	}
+	return CodeInternal
+}
+
+// Language picks the first supported language of an Accept-Language header.
+func Language(acceptLanguage string) string {
+	for _, tag := range strings.Split(acceptLanguage, ",") {
+		tag = strings.ToLower(strings.TrimSpace(strings.Split(tag, ";")[0]))
+		tag = strings.Split(tag, "-")[0]
+		if _, ok := messages[tag]; ok {
+			return tag
+		}
+	}
+	return defaultLanguage
+}
@@ -0,0 +1,111 @@
+package response
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeProblem = "application/problem+json"
+
+	problemTypePrefix = "urn:sense:error:"
+)
+
+// problemLogFields are the Log fields meant for clients, the others are for our logs and never leave the server.
+var problemLogFields = []string{"retry_after"}
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	// Extensions are sent as additional members next to the standard ones
+	Extensions map[string]interface{}
+}
+
+// MarshalJSON flattens the extension members into the problem object.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	body := make(map[string]interface{}, len(p.Extensions)+5)
+	for key, value := range p.Extensions {
+		body[key] = value
+	}
+
+	body["type"] = p.Type
+	body["title"] = p.Title
+	body["status"] = p.Status
+	if p.Detail != "" {
+		body["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		body["instance"] = p.Instance
+	}
+	return json.Marshal(body)
+}
+
+// Problem maps an error response onto problem details. Code and Message become status and detail, the error
+// code identifies the type and the problemLogFields are added as extensions.
+func (r *JSONResponse) Problem(instance string) Problem {
+	problem := Problem{
+		Type:     problemTypePrefix + r.ErrorCode,
+		Title:    r.ErrorString,
+		Status:   r.StatusCode,
+		Detail:   r.Message,
+		Instance: instance,
+		Extensions: map[string]interface{}{
+			"code":       r.Code,
+			"error_code": r.ErrorCode,
+			"latency":    r.Latency,
+		},
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(r.StatusCode)
+	}
+	if len(r.Details) > 0 {
+		problem.Extensions["details"] = r.Details
+	}
+	if r.RequestID != "" {
+		problem.Extensions["request_id"] = r.RequestID
+	}
+	if r.TraceID != "" {
+		problem.Extensions["trace_id"] = r.TraceID
+	}
+
+	for _, key := range problemLogFields {
+		if value, ok := r.Log[key]; ok {
+			problem.Extensions[key] = value
+		}
+	}
+	return problem
+}
+
+// wantsProblem reports whether an Accept header prefers problem details over plain JSON.
+func wantsProblem(accept string) bool {
+	problem, json := -1.0, -1.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+
+		switch mediaType {
+		case ContentTypeProblem:
+			problem = q
+		case ContentTypeJSON:
+			json = q
+		}
+	}
+	return problem > 0 && problem >= json
+}
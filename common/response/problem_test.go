@@ -0,0 +1,127 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWantsProblem(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "*/*", want: false},
+		{accept: "application/json", want: false},
+		{accept: "application/problem+json", want: true},
+		{accept: "Application/Problem+JSON", want: true},
+		{accept: "application/json, application/problem+json", want: true},
+		{accept: "application/problem+json;q=0.5, application/json", want: false},
+		{accept: "application/problem+json, application/json;q=0.9", want: true},
+		{accept: "application/json;q=0.4, application/problem+json;q=0.8", want: true},
+		{accept: "application/problem+json; q=0", want: false},
+		{accept: "application/problem+json;q=oops", want: true},
+		{accept: "text/html, application/problem+json;level=1", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := wantsProblem(tt.accept); got != tt.want {
+			t.Errorf("wantsProblem(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func send(t *testing.T, resp *JSONResponse, accept string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/predict?explain=true", nil)
+	req.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	resp.Send(w, req)
+
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	return w, body
+}
+
+func throttled() *JSONResponse {
+	return NewJSONResponse().SetError(ErrTooManyRequests).SetMessage("Too Many Requests, Try Again Later!").
+		SetLog("retry_after", "30").SetLog("error", errors.New("limiter state")).SetRequestID("req-1")
+}
+
+func TestSendProblem(t *testing.T) {
+	w, body := send(t, throttled(), ContentTypeProblem)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status is %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != ContentTypeProblem {
+		t.Errorf("content type is %q", got)
+	}
+
+	want := map[string]interface{}{
+		"type":        problemTypePrefix + CodeTooManyRequests,
+		"status":      float64(http.StatusTooManyRequests),
+		"detail":      "Too Many Requests, Try Again Later!",
+		"instance":    "/v1/predict",
+		"error_code":  CodeTooManyRequests,
+		"request_id":  "req-1",
+		"retry_after": "30",
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s is %v, want %v", key, body[key], value)
+		}
+	}
+	if body["title"] == "" {
+		t.Error("title is empty")
+	}
+	if _, ok := body["error"]; ok {
+		t.Errorf("internal log field leaked: %v", body["error"])
+	}
+}
+
+func TestSendProblemHidesInternalLog(t *testing.T) {
+	resp := NewJSONResponse().SetError(ErrBadRequest).SetMessage("Bad Request").
+		SetLog("panic", "runtime error").SetLog("query", "token=secret")
+	_, body := send(t, resp, ContentTypeProblem)
+
+	for _, key := range []string{"panic", "query"} {
+		if _, ok := body[key]; ok {
+			t.Errorf("internal log field %s leaked: %v", key, body[key])
+		}
+	}
+}
+
+func TestSendJSON(t *testing.T) {
+	w, body := send(t, throttled(), "application/json, application/problem+json;q=0.5")
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status is %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != ContentTypeJSON {
+		t.Errorf("content type is %q", got)
+	}
+	if body["message"] != "Too Many Requests, Try Again Later!" || body["error_code"] != CodeTooManyRequests || body["request_id"] != "req-1" {
+		t.Errorf("body is %v", body)
+	}
+	if _, ok := body["type"]; ok {
+		t.Error("plain JSON has problem members")
+	}
+}
+
+func TestSendSuccessIgnoresAccept(t *testing.T) {
+	w, body := send(t, NewJSONResponse().SetData("OK"), ContentTypeProblem)
+
+	if got := w.Header().Get("Content-Type"); got != ContentTypeJSON {
+		t.Errorf("content type is %q", got)
+	}
+	if body["data"] != "OK" {
+		t.Errorf("body is %v", body)
+	}
+}
@@ -0,0 +1,87 @@
+package response
+
+import (
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/errors"
+	"reflect"
+	"sync"
+)
+
+// registration maps a sentinel error to the HTTP status and application code of responses failing with it.
+type registration struct {
+	err    error
+	status string
+	code   string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = []registration{
+		{ErrBadRequest, STATUSCODE_BADREQUEST, CodeBadRequest},
+		{ErrForbiddenResource, STATUS_FORBIDDEN, CodeForbidden},
+		{ErrNotFound, STATUSCODE_NOT_FOUND, CodeNotFound},
+		{ErrInternalServerError, STATUSCODE_INTERNAL_ERROR, CodeInternal},
+		{ErrTimeoutError, STATUSCODE_TIMEOUT_ERROR, CodeTimeout},
+		{ErrAlreadyRegistered, STATUSCODE_BADREQUEST, CodeUserAlreadyExists},
+		{ErrNoValidUserFound, STATUSCODE_BADREQUEST, CodeInvalidCredentials},
+		{ErrServiceUnavailable, STATUSCODE_UNAVAILABLE, CodeServiceUnavailable},
+		{ErrTooManyRequests, STATUSCODE_TOO_MANY, CodeTooManyRequests},
+	}
+)
+
+// Register maps a sentinel error, and every error wrapping it, to an HTTP status such as STATUSCODE_NOT_FOUND and
+// an application code. Registering a sentinel again replaces its mapping.
+func Register(err error, status string, code string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for i := range registry {
+		if registry[i].err == err {
+			registry[i] = registration{err, status, code}
+			return
+		}
+	}
+	registry = append(registry, registration{err, status, code})
+}
+
+// lookup finds the registered sentinel of err. The chain is searched from the outside in, so wrapping an error
+// into a chain of another type changes its class but wrapping it with a stack or a message never does.
+func lookup(err error) (registration, bool) {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if reg, ok := lookupOne(e); ok {
+			return reg, true
+		}
+	}
+	return registration{}, false
+}
+
+// lookupOne finds the registered sentinel of err itself, without following the chain.
+func lookupOne(err error) (registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for _, reg := range registry {
+		if matches(err, reg.err) {
+			return reg, true
+		}
+	}
+	return registration{}, false
+}
+
+// matches is one step of errors.Is, without following the chain.
+func matches(err error, target error) bool {
+	if reflect.TypeOf(target).Comparable() && reflect.TypeOf(err) == reflect.TypeOf(target) && err == target {
+		return true
+	}
+	if x, ok := err.(interface{ Is(error) bool }); ok {
+		return x.Is(target)
+	}
+	return false
+}
+
+// findChain returns the outermost custerr.ErrChain in the chain of err.
+func findChain(err error) (custerr.ErrChain, bool) {
+	var chain custerr.ErrChain
+	ok := errors.As(err, &chain)
+	return chain, ok
+}
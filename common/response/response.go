@@ -18,6 +18,8 @@ var (
 	ErrAlreadyRegistered   = errors.New("User already registered")
 	ErrInternalServerError = errors.New("Internal server error")
 	ErrNoValidUserFound    = errors.New("No Valid User Found")
+	ErrServiceUnavailable  = errors.New("Service unavailable")
+	ErrTooManyRequests     = errors.New("Too many requests")
 )
 
 const (
@@ -25,7 +27,9 @@ const (
 	STATUSCODE_BADREQUEST     = "400"
 	STATUS_FORBIDDEN          = "403"
 	STATUSCODE_NOT_FOUND      = "404"
+	STATUSCODE_TOO_MANY       = "429"
 	STATUSCODE_INTERNAL_ERROR = "500"
+	STATUSCODE_UNAVAILABLE    = "503"
 	STATUSCODE_TIMEOUT_ERROR  = "504"
 )
 
@@ -34,34 +38,15 @@ func GetErrorCode(err error) int {
 	return int(code)
 }
 
+// GetErrorCodeStr returns the HTTP status of an error as registered with Register, 500 for unknown errors.
 func GetErrorCodeStr(err error) string {
-	switch err.(type) {
-	case custerr.ErrChain:
-		errType := err.(custerr.ErrChain).Type
-		if errType != nil {
-			err = errType
-		}
-	}
-	switch err {
-	case ErrBadRequest:
-		return STATUSCODE_BADREQUEST
-	case ErrForbiddenResource:
-		return STATUS_FORBIDDEN
-	case ErrNotFound:
-		return STATUSCODE_NOT_FOUND
-	case ErrInternalServerError:
-		return STATUSCODE_INTERNAL_ERROR
-	case ErrTimeoutError:
-		return STATUSCODE_TIMEOUT_ERROR
-	case ErrAlreadyRegistered:
-		return STATUSCODE_BADREQUEST
-	case nil:
+	if err == nil {
 		return STATUSCODE_GENERICSUCCESS
-	case ErrNoValidUserFound:
-		return STATUSCODE_BADREQUEST
-	default:
-		return STATUSCODE_INTERNAL_ERROR
 	}
+	if reg, ok := lookup(err); ok {
+		return reg.status
+	}
+	return STATUSCODE_INTERNAL_ERROR
 }
 
 func GetHTTPCode(code string) int {
@@ -74,10 +59,15 @@ type JSONResponse struct {
 	Code        string                 `json:"code"`
 	Message     string                 `json:"message,omitempty"`
 	ErrorString string                 `json:"error,omitempty"`
+	ErrorCode   string                 `json:"error_code,omitempty"`
+	Details     []custerr.Detail       `json:"details,omitempty"`
+	RequestID   string                 `json:"request_id,omitempty"`
+	TraceID     string                 `json:"trace_id,omitempty"`
 	Data        interface{}            `json:"data,omitempty"`
 	Latency     string                 `json:"latency"`
 	StatusCode  int                    `json:"-"`
 	Error       error                  `json:"-"`
+	Cause       error                  `json:"-"`
 	Log         map[string]interface{} `json:"-"`
 	Success     *int                   `json:"success,omitempty"`
 }
@@ -101,6 +91,14 @@ func (r *JSONResponse) SetMessage(msg string) *JSONResponse {
 	return r
 }
 
+// SetStatus answers with an HTTP status that is not an error, e.g. a 503 of a readiness probe. Error responses
+// use SetError instead.
+func (r *JSONResponse) SetStatus(code int) *JSONResponse {
+	r.StatusCode = code
+	r.Code = strconv.Itoa(code)
+	return r
+}
+
 func (r *JSONResponse) SetLatency(latency float64) *JSONResponse {
 	r.Latency = fmt.Sprintf("%.2f ms", latency)
 	return r
@@ -111,25 +109,56 @@ func (r *JSONResponse) SetLog(key string, val interface{}) *JSONResponse {
 	return r
 }
 
+// getErrType returns the registered sentinel of err, or err itself when it has none.
 func getErrType(err error) error {
-	switch err.(type) {
-	case custerr.ErrChain:
-		errType := err.(custerr.ErrChain).Type
-		if errType != nil {
-			err = errType
-		}
+	if reg, ok := lookup(err); ok {
+		return reg.err
 	}
 	return err
 }
 
+func (r *JSONResponse) SetRequestID(id string) *JSONResponse {
+	r.RequestID = id
+	return r
+}
+
+func (r *JSONResponse) SetTraceID(id string) *JSONResponse {
+	r.TraceID = id
+	return r
+}
+
+// Localize gives an error response without a message the one of its code in lang, see Language. A message the
+// handler wrote is more specific than the translation and is kept.
+func (r *JSONResponse) Localize(lang string) *JSONResponse {
+	if r.ErrorCode == "" || r.Message != "" {
+		return r
+	}
+
+	if message, ok := messages[lang][r.ErrorCode]; ok {
+		r.Message = message
+	}
+	return r
+}
+
 func (r *JSONResponse) SetError(err error, a ...string) *JSONResponse {
+	r.ErrorCode = ErrorCode(err)
+	if chain, ok := findChain(err); ok {
+		r.Details = chain.Details
+	}
+	r.Cause = err
+
 	err = getErrType(err)
 	r.Error = err
-	r.ErrorString = err.Error()
+	r.ErrorString = errorText(err)
 	r.Code = GetErrorCodeStr(err)
 	r.StatusCode = GetHTTPCode(r.Code)
-	if r.StatusCode != http.StatusForbidden {
-		log.Println(err)
+	if r.StatusCode >= http.StatusInternalServerError {
+		// Server errors are reported with a stack by the router, the one of the caller beats none
+		if len(errors.StackFrames(r.Cause)) == 0 {
+			r.Cause = errors.WithStack(r.Cause)
+		}
+	} else if r.StatusCode != http.StatusForbidden {
+		log.Println(errorText(err))
 	}
 	if r.StatusCode == http.StatusInternalServerError {
 		r.ErrorString = "Internal Server error"
@@ -140,10 +169,27 @@ func (r *JSONResponse) SetError(err error, a ...string) *JSONResponse {
 	return r
 }
 
-func (r *JSONResponse) Send(w http.ResponseWriter) {
-	b, _ := json.Marshal(r)
+// errorText is the message of an error without the stack common/errors attaches to it.
+func errorText(err error) string {
+	for cause := errors.Cause(err); cause != nil; cause = errors.Cause(err) {
+		err = cause
+	}
+	return err.Error()
+}
+
+// Send writes the response. Errors are sent as problem details instead when the Accept header of req asks for
+// application/problem+json, req may be nil.
+func (r *JSONResponse) Send(w http.ResponseWriter, req *http.Request) {
+	var b []byte
+	contentType := ContentTypeJSON
+	if r.Error != nil && req != nil && wantsProblem(req.Header.Get("Accept")) {
+		b, _ = json.Marshal(r.Problem(req.URL.Path))
+		contentType = ContentTypeProblem
+	} else {
+		b, _ = json.Marshal(r)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(r.StatusCode)
 	w.Write(b)
 }
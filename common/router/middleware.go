@@ -0,0 +1,111 @@
+package router
+
+import (
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"time"
+)
+
+// Middleware wraps a Handle, e.g. to authorize, log or rate limit requests.
+type Middleware func(Handle) Handle
+
+// Chain wraps handle in the middlewares, the first one runs first.
+func Chain(handle Handle, middlewares ...Middleware) Handle {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handle = middlewares[i](handle)
+	}
+	return handle
+}
+
+// Router registers routes through HandleNow with a chain of middlewares shared by all its routes.
+type Router struct {
+	// Timeouts overrides DefaultTimeout by route path, it is shared with the groups of the router
+	Timeouts map[string]time.Duration
+
+	router      *httprouter.Router
+	prefix      string
+	version     string
+	middlewares []Middleware
+}
+
+// New returns a Router whose middlewares run for every route registered through it or its groups.
+func New(router *httprouter.Router, middlewares ...Middleware) *Router {
+	return &Router{router: router, middlewares: middlewares}
+}
+
+// Use appends global middlewares, they only apply to routes registered afterwards.
+func (r *Router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// Group returns a Router for routes below prefix that runs the middlewares after the ones of r.
+func (r *Router) Group(prefix string, middlewares ...Middleware) *Router {
+	chain := make([]Middleware, 0, len(r.middlewares)+len(middlewares))
+	chain = append(chain, r.middlewares...)
+	chain = append(chain, middlewares...)
+	return &Router{Timeouts: r.Timeouts, router: r.router, prefix: r.prefix + prefix, version: r.version, middlewares: chain}
+}
+
+// Version returns a Router that mounts its routes under /version. The route path seen by middlewares stays
+// unversioned, so per-route config and API key scopes hold for every version, and the version is passed on in
+// the apiVersion header.
+func (r *Router) Version(version string) *Router {
+	group := r.Group("", func(next Handle) Handle {
+		return func(w http.ResponseWriter, req *http.Request) *response.JSONResponse {
+			req.Header.Set("apiVersion", version)
+			return next(w, req)
+		}
+	})
+	group.version = "/" + version
+	return group
+}
+
+// Handle registers a route, the route middlewares run after the ones of the router.
+func (r *Router) Handle(method string, path string, handle Handle, middlewares ...Middleware) {
+	path = r.prefix + path
+	handle = Chain(handle, middlewares...)
+	handle = Chain(handle, r.middlewares...)
+
+	timeout, ok := r.Timeouts[path]
+	if !ok {
+		timeout = DefaultTimeout
+	}
+	r.router.Handle(method, r.version+path, HandleWithTimeout(path, timeout, handle))
+}
+
+func (r *Router) GET(path string, handle Handle, middlewares ...Middleware) {
+	r.Handle(http.MethodGet, path, handle, middlewares...)
+}
+
+func (r *Router) POST(path string, handle Handle, middlewares ...Middleware) {
+	r.Handle(http.MethodPost, path, handle, middlewares...)
+}
+
+func (r *Router) PUT(path string, handle Handle, middlewares ...Middleware) {
+	r.Handle(http.MethodPut, path, handle, middlewares...)
+}
+
+func (r *Router) PATCH(path string, handle Handle, middlewares ...Middleware) {
+	r.Handle(http.MethodPatch, path, handle, middlewares...)
+}
+
+func (r *Router) DELETE(path string, handle Handle, middlewares ...Middleware) {
+	r.Handle(http.MethodDelete, path, handle, middlewares...)
+}
+
+// Logging logs every request with its response code and how long the handler took.
+func Logging(next Handle) Handle {
+	return func(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+		t := time.Now()
+		resp := next(w, r)
+
+		code := "-"
+		if resp != nil {
+			code = resp.Code
+		}
+		log.WithContext(r.Context()).Infof("date=%s, method=%s, url=%s, code=%s, response_time=%s", t, r.Method, r.RequestURI, code, time.Since(t))
+		return resp
+	}
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"context"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+)
+
+type contextKey string
+
+// paramsKey is where HandleNow keeps the path params of the matched route
+const paramsKey contextKey = "HTTPParams"
+
+func withParams(ctx context.Context, ps httprouter.Params) context.Context {
+	return context.WithValue(ctx, paramsKey, ps)
+}
+
+// Params returns the path params of the route that matched the request.
+func Params(r *http.Request) httprouter.Params {
+	ps, _ := r.Context().Value(paramsKey).(httprouter.Params)
+	return ps
+}
+
+// Param returns a path param by name, e.g. "id" for /images/:id, or "" when the route has none.
+func Param(r *http.Request, name string) string {
+	return Params(r).ByName(name)
+}
+
+// ParamInt returns a path param parsed as an integer.
+func ParamInt(r *http.Request, name string) (int, error) {
+	return strconv.Atoi(Param(r, name))
+}
+
+// Query returns a query param, or def when it is missing or empty.
+func Query(r *http.Request, name string, def string) string {
+	if value := r.URL.Query().Get(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// QueryInt returns a query param parsed as an integer, or def when it is missing.
+func QueryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// QueryBool returns a query param parsed as a boolean, missing or malformed values are false.
+func QueryBool(r *http.Request, name string) bool {
+	value, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return value
+}
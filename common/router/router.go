@@ -2,67 +2,201 @@ package router
 
 import (
 	"context"
+	"fmt"
 	"github.com/hansels/sense_backend/common/errors"
 	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/metrics"
+	"github.com/hansels/sense_backend/common/reporter"
 	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/common/trace"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
 type Handle func(http.ResponseWriter, *http.Request) *response.JSONResponse
 
+// DefaultTimeout is how long HandleNow waits for a handler before it answers 504.
+const DefaultTimeout = 30 * time.Second
+
+// statusClientClosedRequest is recorded for requests the client gave up on before they were answered, the code
+// nginx logs for them. Nothing is sent, the client is gone.
+const statusClientClosedRequest = 499
+
+var (
+	requestsTotal   = metrics.NewCounter("http_requests_total", "Requests answered, by route and status code.", "method", "route", "code")
+	requestDuration = metrics.NewHistogram("http_request_duration_seconds", "Time to answer requests, by route.", nil, "method", "route")
+)
+
+// WrittenResponseWriter records whether the handler wrote a response. Once the request timed out, writes of the
+// still running handler are dropped.
 type WrittenResponseWriter struct {
 	http.ResponseWriter
-	written bool
+
+	mu       sync.Mutex
+	written  bool
+	timedOut bool
+	status   int
 }
 
 func (w *WrittenResponseWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.written
 }
 
+func (w *WrittenResponseWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return http.Header{}
+	}
+	return w.ResponseWriter.Header()
+}
+
+func (w *WrittenResponseWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+	if !w.written {
+		w.status = code
+	}
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *WrittenResponseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !w.written {
+		w.status = http.StatusOK
+	}
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Status is the status code the handler wrote, 0 when it wrote nothing.
+func (w *WrittenResponseWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *WrittenResponseWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && !w.timedOut {
+		flusher.Flush()
+	}
+}
+
+// timeout stops the handler from writing and reports whether it had not written anything yet.
+func (w *WrittenResponseWriter) timeout() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timedOut = true
+	return !w.written
+}
+
 func HandleNow(fullPath string, handle Handle) httprouter.Handle {
+	return HandleWithTimeout(fullPath, DefaultTimeout, handle)
+}
+
+// HandleWithTimeout is HandleNow with its own deadline. The request context is cancelled at the deadline, so
+// handlers passing r.Context() on stop their work as well.
+//
+// Every request gets a request ID and a server span, both continue the X-Request-ID and traceparent headers of
+// the caller when it sent them. They are stored in the request context for logs and outgoing calls, see
+// common/trace, and returned in the X-Request-ID header and the response body.
+func HandleWithTimeout(fullPath string, timeout time.Duration, handle Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		t := time.Now()
-		ctx, cancel := context.WithTimeout(r.Context(), time.Second*time.Duration(30))
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
 
 		defer cancel()
 
-		ctx = context.WithValue(ctx, "HTTPParams", ps)
+		ctx = withParams(ctx, ps)
+		ctx = trace.Extract(ctx, r.Header)
+		ctx, span := trace.Start(ctx, r.Method+" "+fullPath, trace.SpanKindServer)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", fullPath)
+		span.SetAttribute("http.target", r.URL.RequestURI())
 
+		defer span.Finish()
+
+		requestID := trace.RequestID(ctx)
+		traceID := span.Context.TraceID.String()
 		r.Header.Set("routePath", fullPath)
+		r.Header.Set(trace.HeaderRequestID, requestID)
 		r = r.WithContext(ctx)
 
-		respChan := make(chan *response.JSONResponse)
+		w.Header().Set(trace.HeaderRequestID, requestID)
+		lang := response.Language(r.Header.Get("Accept-Language"))
+
+		writer := &WrittenResponseWriter{ResponseWriter: w}
+		respChan := make(chan *response.JSONResponse, 1)
 		go func() {
 			defer panicRecover(respChan, r, fullPath)
-			resp := handle(w, r)
+			resp := handle(writer, r)
 			respChan <- resp
 		}()
 
 		select {
 		case <-ctx.Done():
+			// The client is gone or the deadline passed, either way the handler must not write anymore
+			unwritten := writer.timeout()
+			status := statusClientClosedRequest
 			if ctx.Err() == context.DeadlineExceeded {
-				w.WriteHeader(http.StatusGatewayTimeout)
-				w.Write([]byte("timeout")) //TODO: should be custom response
+				status = http.StatusGatewayTimeout
+				span.SetError(ctx.Err())
+				if unwritten {
+					response.NewJSONResponse().SetError(response.ErrTimeoutError).
+						SetLatency(time.Since(t).Seconds()*1000).SetRequestID(requestID).SetTraceID(traceID).
+						Localize(lang).Send(w, r)
+				}
 			}
+			span.SetAttribute("http.status_code", status)
+			observe(r.Method, fullPath, status, t)
 		case resp := <-respChan:
+			if resp != nil {
+				resp.SetRequestID(requestID).SetTraceID(traceID)
+			}
+			reporter.ReportResponse(r, resp, resp != nil && resp.Log["panic"] != nil)
 			if resp != nil {
 				resp.SetLatency(time.Since(t).Seconds() * 1000)
-				resp.Send(w)
-			} else {
-				if w, ok := w.(*WrittenResponseWriter); ok && !w.Written() {
-					log.Println("Error nil response from the handler")
-					w.WriteHeader(http.StatusInternalServerError)
-					w.Write([]byte(""))
+				if resp.Error != nil {
+					resp.Localize(lang)
+				}
+				span.SetAttribute("http.status_code", resp.StatusCode)
+				if resp.StatusCode >= http.StatusInternalServerError {
+					span.SetError(resp.Error)
 				}
+				resp.Send(writer, r)
+			} else if !writer.Written() {
+				log.Println("Error nil response from the handler")
+				writer.WriteHeader(http.StatusInternalServerError)
+				writer.Write([]byte(""))
 			}
+			observe(r.Method, fullPath, writer.Status(), t)
 		}
 
 		return
 	}
 }
 
+// observe counts an answered request in the route metrics.
+func observe(method string, route string, status int, start time.Time) {
+	requestsTotal.Inc(method, route, strconv.Itoa(status))
+	requestDuration.Observe(time.Since(start).Seconds(), method, route)
+}
+
 func panicRecover(resp chan *response.JSONResponse, r *http.Request, path string) {
 	if recov := recover(); recov != nil {
 		var e error
@@ -70,12 +204,15 @@ func panicRecover(resp chan *response.JSONResponse, r *http.Request, path string
 		case string:
 			e = errors.New(t)
 		case error:
-			e = t
+			// Taken while panicking, so the stack still holds the frames that panicked
+			e = errors.WithStack(t)
 		default:
-			e = errors.New("Unknown error")
+			e = errors.New(fmt.Sprint("Unknown error ", t))
 		}
 
-		jsonResponse := response.NewJSONResponse().SetError(e).SetMessage("app panic due to " + e.Error()).SetData(r.URL.Query())
+		// What panicked stays in the error report, clients only learn that something did
+		jsonResponse := response.NewJSONResponse().SetError(e).SetMessage("Internal Server Error").
+			SetLog("panic", fmt.Sprint(recov)).SetLog("query", r.URL.RawQuery)
 
 		select {
 		case resp <- jsonResponse:
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/hansels/sense_backend/common/metrics"
+	"github.com/hansels/sense_backend/common/response"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requestCount reads http_requests_total for a route and status code from the exposition.
+func requestCount(t *testing.T, route string, status int) float64 {
+	t.Helper()
+
+	var buf bytes.Buffer
+	metrics.WriteAll(&buf)
+
+	prefix := `http_requests_total{method="GET",route="` + route + `",code="` + strconv.Itoa(status) + `"} `
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, prefix) {
+			value, err := strconv.ParseFloat(strings.TrimPrefix(line, prefix), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return value
+		}
+	}
+	return 0
+}
+
+// blocking is a handler that only returns once its request is done.
+func blocking(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	<-r.Context().Done()
+	return response.NewJSONResponse().SetData("late")
+}
+
+func TestHandleWithTimeoutDeadline(t *testing.T) {
+	route := "/test/deadline"
+	handle := HandleWithTimeout(route, 10*time.Millisecond, blocking)
+	before := requestCount(t, route, http.StatusGatewayTimeout)
+
+	w := httptest.NewRecorder()
+	handle(w, httptest.NewRequest(http.MethodGet, route, nil), nil)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("answered %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+	if got := requestCount(t, route, http.StatusGatewayTimeout) - before; got != 1 {
+		t.Errorf("counted %v timeouts", got)
+	}
+}
+
+func TestHandleWithTimeoutClientGone(t *testing.T) {
+	route := "/test/cancelled"
+	handle := HandleWithTimeout(route, time.Minute, blocking)
+	before := requestCount(t, route, statusClientClosedRequest)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, route, nil).WithContext(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	w := httptest.NewRecorder()
+	handle(w, r, nil)
+
+	if w.Body.Len() != 0 {
+		t.Errorf("wrote %q to a client that is gone", w.Body.String())
+	}
+	if got := requestCount(t, route, statusClientClosedRequest) - before; got != 1 {
+		t.Errorf("counted %v cancelled requests", got)
+	}
+	if got := requestCount(t, route, http.StatusGatewayTimeout); got != 0 {
+		t.Errorf("counted %v timeouts for a cancelled request", got)
+	}
+}
+
+func TestHandleWithTimeoutAnswered(t *testing.T) {
+	route := "/test/answered"
+	handle := HandleWithTimeout(route, time.Minute, func(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+		return response.NewJSONResponse().SetData("OK")
+	})
+	before := requestCount(t, route, http.StatusOK)
+
+	w := httptest.NewRecorder()
+	handle(w, httptest.NewRequest(http.MethodGet, route, nil), nil)
+
+	if w.Code != http.StatusOK || w.Header().Get("X-Request-ID") == "" {
+		t.Errorf("answered %d with headers %v", w.Code, w.Header())
+	}
+	if got := requestCount(t, route, http.StatusOK) - before; got != 1 {
+		t.Errorf("counted %v answers", got)
+	}
+}
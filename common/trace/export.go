@@ -0,0 +1,273 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+
+	collectorPath    = "/v1/traces"
+	collectorBatch   = 100
+	collectorQueue   = 1000
+	collectorFlush   = 5 * time.Second
+	collectorTimeout = 5 * time.Second
+)
+
+// Exporter receives every finished span that is sampled. Export must not block the request it belongs to.
+type Exporter interface {
+	Export(span *Span)
+}
+
+var (
+	mu       sync.RWMutex
+	exporter Exporter
+)
+
+// SetExporter installs the exporter spans are sent to, nil drops them.
+func SetExporter(e Exporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	exporter = e
+}
+
+func getExporter() Exporter {
+	mu.RLock()
+	defer mu.RUnlock()
+	return exporter
+}
+
+// NewExporter returns the exporter of the given kind, ExporterStdout or ExporterOTLP. The OTLP exporter posts
+// to the collector at endpoint, e.g. http://localhost:4318.
+func NewExporter(kind string, endpoint string, service string, out io.Writer) (Exporter, error) {
+	switch kind {
+	case ExporterStdout:
+		return NewStdoutExporter(out), nil
+	case ExporterOTLP:
+		return NewCollectorExporter(endpoint, service), nil
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", kind)
+}
+
+// StdoutExporter writes every span as one line of JSON.
+type StdoutExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewStdoutExporter(out io.Writer) *StdoutExporter {
+	return &StdoutExporter{out: out}
+}
+
+type stdoutSpan struct {
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Start      time.Time              `json:"start"`
+	DurationMs float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func (e *StdoutExporter) Export(span *Span) {
+	s := stdoutSpan{
+		Name:       span.Name,
+		Kind:       span.Kind.String(),
+		TraceID:    span.Context.TraceID.String(),
+		SpanID:     span.Context.SpanID.String(),
+		Start:      span.Start,
+		DurationMs: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+		Attributes: span.Attributes,
+		Error:      span.Error,
+	}
+	if span.ParentID.IsValid() {
+		s.ParentID = span.ParentID.String()
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, _ = e.out.Write(append(b, '\n'))
+}
+
+// CollectorExporter sends spans in batches to an OpenTelemetry collector using OTLP over HTTP with the JSON
+// encoding. Spans are dropped when the collector falls behind, tracing never slows requests down.
+type CollectorExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	spans    chan *Span
+	flush    chan chan struct{}
+}
+
+func NewCollectorExporter(endpoint string, service string) *CollectorExporter {
+	e := &CollectorExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/") + collectorPath,
+		service:  service,
+		client:   &http.Client{Timeout: collectorTimeout},
+		spans:    make(chan *Span, collectorQueue),
+		flush:    make(chan chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *CollectorExporter) Export(span *Span) {
+	select {
+	case e.spans <- span:
+	default:
+	}
+}
+
+// Flush sends the queued spans and waits until they are out, e.g. before the process exits.
+func (e *CollectorExporter) Flush() {
+	done := make(chan struct{})
+	e.flush <- done
+	<-done
+}
+
+func (e *CollectorExporter) run() {
+	ticker := time.NewTicker(collectorFlush)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, collectorBatch)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			// Logging through common/log would trace the logging, stderr is enough here
+			fmt.Fprintf(os.Stderr, "trace: export of %d spans failed: %v\n", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= collectorBatch {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flush:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			send()
+			close(done)
+		}
+	}
+}
+
+func (e *CollectorExporter) send(batch []*Span) error {
+	body, err := json.Marshal(otlpRequest(e.service, batch))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP span kinds and status codes, see opentelemetry-proto trace.proto
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpKindClient   = 3
+
+	otlpStatusError = 2
+)
+
+func otlpRequest(service string, batch []*Span) map[string]interface{} {
+	spans := make([]interface{}, 0, len(batch))
+	for _, span := range batch {
+		kind := otlpKindInternal
+		switch span.Kind {
+		case SpanKindServer:
+			kind = otlpKindServer
+		case SpanKindClient:
+			kind = otlpKindClient
+		}
+
+		s := map[string]interface{}{
+			"traceId":           span.Context.TraceID.String(),
+			"spanId":            span.Context.SpanID.String(),
+			"name":              span.Name,
+			"kind":              kind,
+			"startTimeUnixNano": fmt.Sprint(span.Start.UnixNano()),
+			"endTimeUnixNano":   fmt.Sprint(span.End.UnixNano()),
+			"attributes":        otlpAttributes(span.Attributes),
+		}
+		if span.ParentID.IsValid() {
+			s["parentSpanId"] = span.ParentID.String()
+		}
+		if span.Error != "" {
+			s["status"] = map[string]interface{}{"code": otlpStatusError, "message": span.Error}
+		}
+		spans = append(spans, s)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "github.com/hansels/sense_backend/common/trace"},
+				"spans": spans,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	out := make([]interface{}, 0, len(attributes))
+	for key, value := range attributes {
+		var v map[string]interface{}
+		switch t := value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": t}
+		case int:
+			v = map[string]interface{}{"intValue": fmt.Sprint(t)}
+		case int64:
+			v = map[string]interface{}{"intValue": fmt.Sprint(t)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": t}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(t)}
+		}
+		out = append(out, map[string]interface{}{"key": key, "value": v})
+	}
+	return out
+}
@@ -0,0 +1,213 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type otlpSpan struct {
+	TraceID           string `json:"traceId"`
+	SpanID            string `json:"spanId"`
+	ParentSpanID      string `json:"parentSpanId"`
+	Name              string `json:"name"`
+	Kind              int    `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string `json:"endTimeUnixNano"`
+	Attributes        []struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	} `json:"attributes"`
+	Status *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type otlpExport struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string            `json:"key"`
+				Value map[string]string `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+// collector stands in for an OpenTelemetry collector, answering with status.
+type collector struct {
+	mu      sync.Mutex
+	exports []otlpExport
+	paths   []string
+	types   []string
+	status  int
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var export otlpExport
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.exports = append(c.exports, export)
+	c.paths = append(c.paths, r.URL.Path)
+	c.types = append(c.types, r.Header.Get("Content-Type"))
+	status := c.status
+	c.mu.Unlock()
+
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+}
+
+func (c *collector) spans() []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var spans []otlpSpan
+	for _, export := range c.exports {
+		for _, rs := range export.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func TestCollectorExporter(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	e := NewCollectorExporter(server.URL+"/", "sense-test")
+
+	ctx, parent := Start(context.Background(), "GET /predict", SpanKindServer)
+	parent.SetAttribute("http.status_code", 500)
+	parent.SetAttribute("http.route", "/predict")
+	parent.SetAttribute("retried", true)
+	parent.SetError(errors.New("model failed"))
+	_, child := Start(ctx, "firestore.Get", SpanKindClient)
+	child.End = child.Start.Add(time.Millisecond)
+	parent.End = parent.Start.Add(2 * time.Millisecond)
+
+	e.Export(child)
+	e.Export(parent)
+	e.Flush()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.paths) != 1 || c.paths[0] != collectorPath || c.types[0] != "application/json" {
+		t.Fatalf("collector got %v with %v", c.paths, c.types)
+	}
+
+	resource := c.exports[0].ResourceSpans[0].Resource.Attributes
+	if len(resource) != 1 || resource[0].Key != "service.name" || resource[0].Value["stringValue"] != "sense-test" {
+		t.Errorf("resource is %+v", resource)
+	}
+
+	spans := c.exports[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	gotChild, gotParent := spans[0], spans[1]
+
+	if gotChild.Name != "firestore.Get" || gotChild.Kind != otlpKindClient || gotChild.TraceID != parent.Context.TraceID.String() ||
+		gotChild.ParentSpanID != parent.Context.SpanID.String() || gotChild.Status != nil {
+		t.Errorf("child span is %+v", gotChild)
+	}
+	if gotParent.Name != "GET /predict" || gotParent.Kind != otlpKindServer || gotParent.ParentSpanID != "" ||
+		gotParent.SpanID != parent.Context.SpanID.String() {
+		t.Errorf("parent span is %+v", gotParent)
+	}
+	if gotParent.Status == nil || gotParent.Status.Code != otlpStatusError || gotParent.Status.Message != "model failed" {
+		t.Errorf("parent status is %+v", gotParent.Status)
+	}
+	if gotParent.StartTimeUnixNano != fmt.Sprint(parent.Start.UnixNano()) || gotParent.EndTimeUnixNano != fmt.Sprint(parent.End.UnixNano()) {
+		t.Errorf("parent ran from %s to %s", gotParent.StartTimeUnixNano, gotParent.EndTimeUnixNano)
+	}
+
+	attributes := map[string]map[string]interface{}{}
+	for _, a := range gotParent.Attributes {
+		attributes[a.Key] = a.Value
+	}
+	if attributes["http.status_code"]["intValue"] != "500" || attributes["http.route"]["stringValue"] != "/predict" ||
+		attributes["retried"]["boolValue"] != true {
+		t.Errorf("attributes are %v", attributes)
+	}
+}
+
+func TestCollectorExporterFlushSendsQueuedSpans(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	e := NewCollectorExporter(server.URL, "sense-test")
+	for i := 0; i < collectorBatch+1; i++ {
+		_, span := Start(context.Background(), "span", SpanKindInternal)
+		span.End = time.Now()
+		e.Export(span)
+	}
+	e.Flush()
+
+	if n := len(c.spans()); n != collectorBatch+1 {
+		t.Errorf("sent %d spans, want %d", n, collectorBatch+1)
+	}
+}
+
+func TestCollectorExporterSendError(t *testing.T) {
+	c := &collector{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	e := NewCollectorExporter(server.URL, "sense-test")
+	_, span := Start(context.Background(), "span", SpanKindInternal)
+	span.End = time.Now()
+
+	err := e.send([]*Span{span})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("got %v, want the collector status", err)
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var out bytes.Buffer
+	e := NewStdoutExporter(&out)
+
+	_, span := Start(context.Background(), "job", SpanKindInternal)
+	span.SetAttribute("job_id", "42")
+	span.End = span.Start.Add(1500 * time.Microsecond)
+	e.Export(span)
+
+	var got stdoutSpan
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("output is not one JSON line: %q", out.String())
+	}
+	if got.Name != "job" || got.Kind != "internal" || got.TraceID != span.Context.TraceID.String() ||
+		got.DurationMs != 1.5 || got.Attributes["job_id"] != "42" || got.ParentID != "" {
+		t.Errorf("exported %+v", got)
+	}
+}
+
+func TestNewExporter(t *testing.T) {
+	if _, err := NewExporter("zipkin", "", "sense", nil); err == nil {
+		t.Error("unknown exporter accepted")
+	}
+	if e, err := NewExporter(ExporterStdout, "", "sense", &bytes.Buffer{}); err != nil || e == nil {
+		t.Errorf("stdout exporter: %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+// Package trace correlates the work done for a request. It carries the request ID and the W3C trace context
+// (https://www.w3.org/TR/trace-context/) in the context, propagates them on outgoing calls and records spans
+// that are handed to the configured Exporter.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderRequestID   = "X-Request-ID"
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+
+	traceParentVersion = "00"
+	flagSampled        = 0x01
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext identifies a span across processes, it is what traceparent carries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+	// State is the vendor specific tracestate, passed on untouched
+	State string
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// TraceParent formats sc as a traceparent header value.
+func (sc SpanContext) TraceParent() string {
+	var flags byte
+	if sc.Sampled {
+		flags |= flagSampled
+	}
+	return fmt.Sprintf("%s-%s-%s-%02x", traceParentVersion, sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceParent reads a traceparent header value. Unknown future versions are accepted as long as they
+// start with the fields of version 00.
+func ParseTraceParent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == traceParentVersion && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	for _, part := range parts[:4] {
+		if !isLowerHex(part) {
+			return sc, false
+		}
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+
+	sc.Sampled = flags[0]&flagSampled != 0
+	return sc, sc.IsValid()
+}
+
+// isLowerHex reports whether s only has the lowercase hex digits traceparent is written in.
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	requestIDKey
+)
+
+// WithRequestID stores the request ID in the context.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID returns the request ID stored in the context, or an empty string.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// FromContext returns the span context of the current span, invalid when there is none.
+func FromContext(ctx context.Context) SpanContext {
+	switch s := ctx.Value(spanKey).(type) {
+	case *Span:
+		return s.Context
+	case SpanContext:
+		return s
+	}
+	return SpanContext{}
+}
+
+// WithRemoteParent stores a span context received from another process, spans started from the returned
+// context become its children.
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey, sc)
+}
+
+// SpanFromContext returns the span started in this process the context belongs to, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// Span is one timed operation of a trace.
+type Span struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	ParentID   SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// Start starts a span as child of the span in ctx, or as the root of a new trace, and returns a context that
+// carries it. Spans are always recorded, Sampled only tells the exporter and the next hops what to keep.
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := FromContext(ctx)
+
+	span := &Span{Name: name, Kind: kind, Start: time.Now(), Attributes: map[string]interface{}{}}
+	span.Context.SpanID = newSpanID()
+	if parent.IsValid() {
+		span.ParentID = parent.SpanID
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.Context.State = parent.State
+	} else {
+		span.Context.TraceID = newTraceID()
+		span.Context.Sampled = true
+	}
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// SetAttribute records a key value pair on the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span and exports it, only the first call counts.
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if exporter := getExporter(); exporter != nil && s.Context.Sampled {
+		exporter.Export(s)
+	}
+}
+
+// maxRequestIDLength bounds the request IDs accepted from clients, a UUID or a trace ID fits easily.
+const maxRequestIDLength = 128
+
+// validRequestID only accepts request IDs that are safe to put in logs, headers and response bodies.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Extract reads the request ID and the trace context of an incoming request. A missing or invalid request ID is
+// taken from the trace, so both stay correlated, and generated when there is no trace either.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := ParseTraceParent(header.Get(HeaderTraceParent))
+	if ok {
+		sc.State = header.Get(HeaderTraceState)
+		ctx = WithRemoteParent(ctx, sc)
+	}
+
+	requestID := header.Get(HeaderRequestID)
+	if !validRequestID(requestID) {
+		requestID = ""
+	}
+	if requestID == "" && ok {
+		requestID = sc.TraceID.String()
+	}
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+	return WithRequestID(ctx, requestID)
+}
+
+// Inject sets the request ID and the trace context of ctx on the headers of an outgoing call.
+func Inject(ctx context.Context, header http.Header) {
+	if requestID := RequestID(ctx); requestID != "" {
+		header.Set(HeaderRequestID, requestID)
+	}
+
+	sc := FromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	header.Set(HeaderTraceParent, sc.TraceParent())
+	if sc.State != "" {
+		header.Set(HeaderTraceState, sc.State)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
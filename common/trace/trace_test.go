@@ -0,0 +1,169 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{name: "sampled", value: "00-" + testTraceID + "-" + testSpanID + "-01", ok: true, sampled: true},
+		{name: "not sampled", value: "00-" + testTraceID + "-" + testSpanID + "-00", ok: true},
+		{name: "other flags", value: "00-" + testTraceID + "-" + testSpanID + "-09", ok: true, sampled: true},
+		{name: "surrounding space", value: " 00-" + testTraceID + "-" + testSpanID + "-01 ", ok: true, sampled: true},
+		{name: "future version", value: "01-" + testTraceID + "-" + testSpanID + "-01", ok: true, sampled: true},
+		{name: "future version with more fields", value: "cc-" + testTraceID + "-" + testSpanID + "-01-what-the-future-holds", ok: true, sampled: true},
+		{name: "version 00 with more fields", value: "00-" + testTraceID + "-" + testSpanID + "-01-extra"},
+		{name: "version ff", value: "ff-" + testTraceID + "-" + testSpanID + "-01"},
+		{name: "version not hex", value: "zz-" + testTraceID + "-" + testSpanID + "-01"},
+		{name: "version too long", value: "000-" + testTraceID + "-" + testSpanID + "-01"},
+		{name: "empty", value: ""},
+		{name: "too few fields", value: "00-" + testTraceID + "-" + testSpanID},
+		{name: "short trace id", value: "00-" + testTraceID[1:] + "-" + testSpanID + "-01"},
+		{name: "long span id", value: "00-" + testTraceID + "-" + testSpanID + "0-01"},
+		{name: "uppercase trace id", value: "00-" + strings.ToUpper(testTraceID) + "-" + testSpanID + "-01"},
+		{name: "trace id not hex", value: "00-" + strings.Repeat("g", 32) + "-" + testSpanID + "-01"},
+		{name: "span id not hex", value: "00-" + testTraceID + "-" + strings.Repeat("x", 16) + "-01"},
+		{name: "flags not hex", value: "00-" + testTraceID + "-" + testSpanID + "-0x"},
+		{name: "long flags", value: "00-" + testTraceID + "-" + testSpanID + "-001"},
+		{name: "zero trace id", value: "00-" + strings.Repeat("0", 32) + "-" + testSpanID + "-01"},
+		{name: "zero span id", value: "00-" + testTraceID + "-" + strings.Repeat("0", 16) + "-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceParent(tt.value)
+			if ok != tt.ok {
+				t.Fatalf("ParseTraceParent(%q) ok = %v, want %v", tt.value, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testSpanID || sc.Sampled != tt.sampled {
+				t.Errorf("ParseTraceParent(%q) = %s %s %v", tt.value, sc.TraceID, sc.SpanID, sc.Sampled)
+			}
+		})
+	}
+}
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		sc := SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: sampled}
+		parsed, ok := ParseTraceParent(sc.TraceParent())
+		if !ok || parsed != sc {
+			t.Errorf("%s parsed as %+v, %v", sc.TraceParent(), parsed, ok)
+		}
+	}
+}
+
+func TestExtractRequestID(t *testing.T) {
+	traceParent := "00-" + testTraceID + "-" + testSpanID + "-01"
+
+	tests := []struct {
+		name        string
+		requestID   string
+		traceParent string
+		want        string
+	}{
+		{name: "given", requestID: "7f1c2d3e-req", traceParent: traceParent, want: "7f1c2d3e-req"},
+		{name: "allowed punctuation", requestID: "svc:web.1_a-b", want: "svc:web.1_a-b"},
+		{name: "missing uses trace", traceParent: traceParent, want: testTraceID},
+		{name: "spaces use trace", requestID: "a b", traceParent: traceParent, want: testTraceID},
+		{name: "newline uses trace", requestID: "abc\nINFO forged log line", traceParent: traceParent, want: testTraceID},
+		{name: "markup uses trace", requestID: "<script>", traceParent: traceParent, want: testTraceID},
+		{name: "non ascii uses trace", requestID: "réquest", traceParent: traceParent, want: testTraceID},
+		{name: "too long uses trace", requestID: strings.Repeat("a", maxRequestIDLength+1), traceParent: traceParent, want: testTraceID},
+		{name: "longest kept", requestID: strings.Repeat("a", maxRequestIDLength), want: strings.Repeat("a", maxRequestIDLength)},
+		{name: "invalid trace", requestID: "bad id", traceParent: "00-" + strings.Repeat("0", 32) + "-" + testSpanID + "-01"},
+		{name: "nothing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.requestID != "" {
+				header.Set(HeaderRequestID, tt.requestID)
+			}
+			if tt.traceParent != "" {
+				header.Set(HeaderTraceParent, tt.traceParent)
+			}
+
+			got := RequestID(Extract(context.Background(), header))
+			if tt.want == "" {
+				// Generated
+				if got == "" || got == tt.requestID || got == testTraceID || !validRequestID(got) {
+					t.Errorf("request id is %q, want a generated one", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("request id is %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractTraceContext(t *testing.T) {
+	header := http.Header{}
+	header.Set(HeaderTraceParent, "00-"+testTraceID+"-"+testSpanID+"-00")
+	header.Set(HeaderTraceState, "vendor=value")
+
+	ctx := Extract(context.Background(), header)
+	sc := FromContext(ctx)
+	if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testSpanID || sc.Sampled || sc.State != "vendor=value" {
+		t.Errorf("extracted %+v", sc)
+	}
+
+	// Spans continue the remote trace
+	_, span := Start(ctx, "child", SpanKindServer)
+	if span.Context.TraceID.String() != testTraceID || span.ParentID.String() != testSpanID || span.Context.Sampled {
+		t.Errorf("child span is %+v", span.Context)
+	}
+
+	// An invalid traceparent is ignored, tracestate goes with it
+	header.Set(HeaderTraceParent, "garbage")
+	if sc := FromContext(Extract(context.Background(), header)); sc.IsValid() {
+		t.Errorf("extracted %+v from an invalid traceparent", sc)
+	}
+}
+
+func TestInjectExtractRoundTrip(t *testing.T) {
+	incoming := http.Header{}
+	incoming.Set(HeaderRequestID, "req-42")
+	incoming.Set(HeaderTraceParent, "00-"+testTraceID+"-"+testSpanID+"-01")
+	incoming.Set(HeaderTraceState, "vendor=value")
+
+	ctx, span := Start(Extract(context.Background(), incoming), "call", SpanKindClient)
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+
+	next := Extract(context.Background(), outgoing)
+	if got := RequestID(next); got != "req-42" {
+		t.Errorf("request id is %q", got)
+	}
+	sc := FromContext(next)
+	if sc.TraceID.String() != testTraceID || sc.SpanID != span.Context.SpanID || !sc.Sampled || sc.State != "vendor=value" {
+		t.Errorf("next hop sees %+v, want the span %+v", sc, span.Context)
+	}
+}
+
+func TestInjectWithoutTrace(t *testing.T) {
+	header := http.Header{}
+	Inject(context.Background(), header)
+	if len(header) != 0 {
+		t.Errorf("injected %v", header)
+	}
+}
@@ -1,9 +1,119 @@
 package config
 
+import (
+	"os"
+	"strings"
+	"time"
+)
+
 const (
 	PasswordSalt          = "$@inTS31YA"
 	FirebaseProjectId     = "hackathon-bncc-2021"
 	FirebaseStorageBucket = "hackathon-bncc-2021.appspot.com"
+	MaxBatchPredictImages = 10
+	MaxUploadImageSize    = 10 << 20
+	MaxUploadImagePixels  = 40000000
+	UploadImageQuality    = 90
+	ThumbnailSize         = 256
+	PredictionJobWorkers  = 2
+	PredictionJobQueue    = 100
+	PredictionJobTimeout  = 5 * time.Minute
+	WebhookTimeout        = 10 * time.Second
+	SignedURLExpiry       = 15 * time.Minute
+	UploadRetries         = 3
+	UploadRetryBackoff    = 200 * time.Millisecond
+	UploadCleanupTimeout  = 10 * time.Second
+	ImageRetentionDays    = 90
+	RetentionSweepEvery   = time.Hour
+	ExportSyncLimit       = 50
+	MaxExportSize         = 512 << 20
+	ExportTimeout         = 10 * time.Minute
+
+	LoginFreeAttempts      = 3
+	LoginLockoutAttempts   = 10
+	LoginIPFreeAttempts    = 20
+	LoginIPLockoutAttempts = 100
+	LoginBackoffBase       = time.Second
+	LoginBackoffMax        = 5 * time.Minute
+	LoginLockoutDuration   = 30 * time.Minute
+	LoginAttemptWindow     = time.Hour
+
+	TOTPIssuer               = "Sense"
+	TOTPRecoveryCodes        = 10
+	TwoFactorChallengeExpiry = 5 * time.Minute
+
+	APIKeyLastUsedInterval = time.Minute
+
+	TraceServiceName = "sense-backend"
+
+	ReadinessCheckTimeout = 5 * time.Second
+	ReadinessCacheTTL     = 5 * time.Second
+	ShutdownDrainDelay    = 5 * time.Second
+	ShutdownTimeout       = 30 * time.Second
 )
 
+// RateLimit allows Requests per Per on average for every client of a route, with bursts of up to Burst requests.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// DefaultRateLimit applies to rate limited routes missing from RateLimits.
+var DefaultRateLimit = RateLimit{Requests: 60, Per: time.Minute, Burst: 20}
+
+var RateLimits = map[string]RateLimit{
+	"/predict":         {Requests: 30, Per: time.Minute, Burst: 10},
+	"/predict/batch":   {Requests: 5, Per: time.Minute, Burst: 2},
+	"/predict/jobs":    {Requests: 30, Per: time.Minute, Burst: 10},
+	"/internal/resort": {Requests: 60, Per: time.Minute, Burst: 20},
+}
+
+// RouteTimeouts are the routes that need more, or less, than the default 30 seconds.
+var RouteTimeouts = map[string]time.Duration{
+	"/predict/batch":   2 * time.Minute,
+	"/me":              time.Minute,
+	"/me/export":       2 * time.Minute,
+	"/me/images":       time.Minute,
+	"/login":           10 * time.Second,
+	"/login/2fa":       10 * time.Second,
+	"/login/firebase":  10 * time.Second,
+	"/internal/resort": 10 * time.Second,
+}
+
 var SignatureKey = []byte("BesokItuHariApa?")
+
+var WebhookSecret = "LusaItuHariApa?"
+
+// ErrorReportDSN is the Sentry-compatible DSN server errors are forwarded to, they are only logged when empty.
+var ErrorReportDSN = os.Getenv("SENTRY_DSN")
+
+// TraceExporter is where spans go, "stdout" or "otlp" for the collector at TraceCollectorURL. Spans are dropped
+// when empty, request IDs and trace context are propagated regardless.
+var TraceExporter = os.Getenv("TRACE_EXPORTER")
+
+var TraceCollectorURL = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+
+// TrustedProxies are the addresses or CIDR ranges of the proxies in front of the API, comma separated. The
+// X-Forwarded-For header is only believed on requests coming from one of them.
+var TrustedProxies = splitList(os.Getenv("TRUSTED_PROXIES"))
+
+// MetricsToken is the bearer token Prometheus must send to scrape /metrics, which is open when it is empty.
+var MetricsToken = os.Getenv("METRICS_TOKEN")
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnv(key string, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
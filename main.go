@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
 	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/reporter"
+	"github.com/hansels/sense_backend/common/trace"
+	"github.com/hansels/sense_backend/config"
 	"github.com/hansels/sense_backend/src/firebase"
 	"github.com/hansels/sense_backend/src/ml"
 	"github.com/hansels/sense_backend/src/sense"
@@ -9,6 +13,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -16,8 +21,30 @@ func main() {
 }
 
 func Main() int {
+	if config.ErrorReportDSN != "" {
+		sentry, err := reporter.NewSentry(config.ErrorReportDSN)
+		if err != nil {
+			log.Errorf("Error configuring error reports: %v", err)
+		} else {
+			reporter.SetForwarder(sentry)
+		}
+	}
+
+	if config.TraceExporter != "" {
+		exporter, err := trace.NewExporter(config.TraceExporter, config.TraceCollectorURL, config.TraceServiceName, os.Stdout)
+		if err != nil {
+			log.Errorf("Error configuring tracing: %v", err)
+		} else {
+			trace.SetExporter(exporter)
+			if collector, ok := exporter.(*trace.CollectorExporter); ok {
+				defer collector.Flush()
+			}
+		}
+	}
+
 	firestore := firebase.InitFirestore()
 	storage := firebase.InitStorage()
+	auth := firebase.InitAuth()
 
 	model := ml.NewCoco()
 	err := model.Load()
@@ -26,8 +53,10 @@ func Main() int {
 		panic(err)
 	}
 
-	opts := &sense.Opts{Firestore: firestore, Storage: storage, Model: model}
+	opts := &sense.Opts{Firestore: firestore, Storage: storage, Model: model, Verifier: auth}
 	modules := sense.New(opts)
+	modules.StartJobWorkers()
+	modules.StartRetentionSweeper()
 
 	api := server.New(&server.Opts{ListenAddress: ":3001", Modules: modules})
 
@@ -37,8 +66,31 @@ func Main() int {
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 	select {
 	case s := <-term:
-		_ = firestore.Close()
 		log.Println("Exiting gracefully...", s)
+
+		// Fail readiness first and give load balancers time to notice before connections are refused
+		modules.Shutdown()
+		time.Sleep(config.ShutdownDrainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		if err := api.Shutdown(ctx); err != nil {
+			log.Errorf("Error shutting down server: %v", err)
+		}
+		// Background work still writes to Firestore, so it ends before the client is closed
+		if err := modules.Stop(ctx); err != nil {
+			log.Errorf("Error stopping background work: %v", err)
+		}
+		cancel()
+		_ = firestore.Close()
+	case err := <-api.ListenError():
+		log.Errorf("Error listening: %v", err)
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		if err := modules.Stop(ctx); err != nil {
+			log.Errorf("Error stopping background work: %v", err)
+		}
+		cancel()
+		_ = firestore.Close()
+		return 1
 	}
 	log.Info("👋")
 
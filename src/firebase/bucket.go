@@ -0,0 +1,57 @@
+package firebase
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"google.golang.org/api/iterator"
+	"io"
+)
+
+// Bucket is what Storage needs from a storage bucket, a *storage.BucketHandle through NewBucket outside tests.
+// Missing objects are reported as storage.ErrObjectNotExist.
+type Bucket interface {
+	// NewWriter creates or replaces an object when closed, cancelling ctx before closing aborts the upload.
+	NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) io.WriteCloser
+	NewReader(ctx context.Context, name string) (io.ReadCloser, error)
+	Delete(ctx context.Context, name string) error
+	UpdateMetadata(ctx context.Context, name string, metadata map[string]string) error
+	// Ping fails when the bucket is unreachable or access is denied.
+	Ping(ctx context.Context) error
+}
+
+type gcsBucket struct {
+	bucket *storage.BucketHandle
+}
+
+func NewBucket(bucket *storage.BucketHandle) Bucket {
+	return &gcsBucket{bucket: bucket}
+}
+
+func (b *gcsBucket) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) io.WriteCloser {
+	writer := b.bucket.Object(name).NewWriter(ctx)
+	writer.ObjectAttrs.ContentType = contentType
+	writer.ObjectAttrs.Metadata = metadata
+	return writer
+}
+
+func (b *gcsBucket) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.bucket.Object(name).NewReader(ctx)
+}
+
+func (b *gcsBucket) Delete(ctx context.Context, name string) error {
+	return b.bucket.Object(name).Delete(ctx)
+}
+
+func (b *gcsBucket) UpdateMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	_, err := b.bucket.Object(name).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	return err
+}
+
+// Ping lists at most one object of the bucket.
+func (b *gcsBucket) Ping(ctx context.Context) error {
+	_, err := b.bucket.Objects(ctx, &storage.Query{Prefix: "profile_"}).Next()
+	if err == iterator.Done {
+		return nil
+	}
+	return err
+}
@@ -5,23 +5,58 @@ import (
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
 	"context"
+	"encoding/json"
+	"errors"
 	firebase "firebase.google.com/go"
 	"firebase.google.com/go/auth"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/metrics"
 	"github.com/hansels/sense_backend/config"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
 	"io"
-	"strings"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	credentialsFile = "./files/firebase/firebase.json"
+	tokenMetadata   = "firebaseStorageDownloadTokens"
+)
+
+const (
+	uploadSuccess = "success"
+	uploadRetry   = "retry"
+	uploadFailure = "failure"
+	uploadCleanup = "cleanup"
 )
 
 var (
 	App *firebase.App
+
+	ErrSigningUnavailable = errors.New("URL signing credentials unavailable")
+	ErrExportTooLarge     = errors.New("Export is too large")
+
+	uploadsTotal   = metrics.NewCounter("sense_storage_uploads_total", "Storage upload attempts, by outcome.", "outcome")
+	uploadDuration = metrics.NewHistogram("sense_storage_upload_duration_seconds", "Time to upload an object including retries, by result.", nil, "result")
 )
 
 type Storage struct {
-	FirebaseStorage *storage.BucketHandle
+	FirebaseStorage Bucket
+
+	signer *signer
+}
+
+// signer holds the service account used to sign V4 URLs.
+type signer struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
 }
 
 type UploadImageData struct {
@@ -30,8 +65,14 @@ type UploadImageData struct {
 	File     []byte
 }
 
+type UploadedImage struct {
+	URL          string
+	ThumbnailURL string
+	Data         []byte
+}
+
 func init() {
-	opt := option.WithCredentialsFile("./files/firebase/firebase.json")
+	opt := option.WithCredentialsFile(credentialsFile)
 	cfg := &firebase.Config{
 		ProjectID:     config.FirebaseProjectId,
 		StorageBucket: config.FirebaseStorageBucket,
@@ -49,28 +90,53 @@ func init() {
 func InitStorage() *Storage {
 	client, err := App.Storage(context.Background())
 	if err != nil {
-		log.Fatalln("error initializing storage: %v\n", err)
+		log.Fatalf("error initializing storage: %v\n", err)
 	}
 
 	bucket, err := client.DefaultBucket()
 	if err != nil {
-		log.Fatalln("error initializing default bucket: %v\n", err)
+		log.Fatalf("error initializing default bucket: %v\n", err)
 	}
 
-	return &Storage{FirebaseStorage: bucket}
+	signer, err := loadSigner()
+	if err != nil {
+		log.Warnf("signed urls unavailable: %v\n", err)
+	}
+
+	return &Storage{FirebaseStorage: NewBucket(bucket), signer: signer}
+}
+
+func loadSigner() (*signer, error) {
+	credentials, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &signer{}
+	if err := json.Unmarshal(credentials, s); err != nil {
+		return nil, err
+	}
+	if s.ClientEmail == "" || s.PrivateKey == "" {
+		return nil, ErrSigningUnavailable
+	}
+	return s, nil
 }
 
 func InitAuth() *auth.Client {
 	client, err := App.Auth(context.Background())
 	if err != nil {
-		log.Fatalln("error initializing authentication: %v\n", err)
+		log.Fatalf("error initializing authentication: %v\n", err)
 	}
 
 	return client
 }
 
+// InitFirestore connects to Firestore the way App.Firestore does, with the calls timed for the metrics.
 func InitFirestore() *firestore.Client {
-	fs, err := App.Firestore(context.Background())
+	fs, err := firestore.NewClient(context.Background(), config.FirebaseProjectId,
+		option.WithCredentialsFile(credentialsFile),
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(timeUnary)),
+		option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(timeStream)))
 	if err != nil {
 		log.Fatalf("error initializing firestore: %v\n", err)
 	}
@@ -78,26 +144,237 @@ func InitFirestore() *firestore.Client {
 	return fs
 }
 
-func (s *Storage) UploadImage(data UploadImageData) (string, error) {
+// UploadImage normalizes an image with NormalizeImage and uploads it together with its thumbnail. Both objects
+// share one download token. When the thumbnail fails the original is removed again.
+func (s *Storage) UploadImage(data UploadImageData) (*UploadedImage, error) {
+	normalized, err := NormalizeImage(data.File)
+	if err != nil {
+		return nil, err
+	}
+
 	id := uuid.New()
+	thumbnail := UploadImageData{Ctx: data.Ctx, FileName: ThumbnailName(data.FileName), File: normalized.Thumbnail}
+
+	if err := s.upload(data.Ctx, data.FileName, "image/jpeg", id.String(), normalized.Data); err != nil {
+		return nil, err
+	}
+	if err := s.upload(thumbnail.Ctx, thumbnail.FileName, "image/jpeg", id.String(), thumbnail.File); err != nil {
+		s.cleanup(data.FileName)
+		return nil, err
+	}
 
-	object := s.FirebaseStorage.Object(data.FileName)
-	writer := object.NewWriter(data.Ctx)
-	writer.ObjectAttrs.Metadata = map[string]string{"firebaseStorageDownloadTokens": id.String()}
-	defer writer.Close()
+	return &UploadedImage{
+		URL:          s.GenerateURL(data.FileName, id.String()),
+		ThumbnailURL: s.GenerateURL(thumbnail.FileName, id.String()),
+		Data:         normalized.Data,
+	}, nil
+}
 
-	if _, err := io.Copy(writer, bytes.NewReader(data.File)); err != nil {
+// UploadFile uploads a file we generated ourselves as is, e.g. saliency overlays.
+func (s *Storage) UploadFile(data UploadImageData, contentType string) (string, error) {
+	if len(data.File) > config.MaxUploadImageSize {
+		return "", ErrImageTooLarge
+	}
+
+	id := uuid.New()
+	if err := s.upload(data.Ctx, data.FileName, contentType, id.String(), data.File); err != nil {
 		return "", err
 	}
 
-	url := s.GenerateURL(data, id.String())
-	return url, nil
+	return s.GenerateURL(data.FileName, id.String()), nil
+}
+
+// upload writes an object, retrying transient failures with exponential backoff. Whatever a failed upload may
+// have left behind is deleted.
+func (s *Storage) upload(ctx context.Context, fileName string, contentType string, token string, file []byte) error {
+	t := time.Now()
+	backoff := config.UploadRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.write(ctx, fileName, contentType, token, file)
+		if err == nil {
+			countUpload(uploadSuccess)
+			uploadDuration.Observe(time.Since(t).Seconds(), uploadSuccess)
+			return nil
+		}
+
+		if attempt >= config.UploadRetries || !isTransient(err) {
+			countUpload(uploadFailure)
+			uploadDuration.Observe(time.Since(t).Seconds(), uploadFailure)
+			log.Errorf("Upload %s failed after %d attempts : %+v", fileName, attempt, err)
+			s.cleanup(fileName)
+			return err
+		}
+
+		countUpload(uploadRetry)
+		log.Warnf("Upload %s attempt %d failed, retrying in %s : %+v", fileName, attempt, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			countUpload(uploadFailure)
+			uploadDuration.Observe(time.Since(t).Seconds(), uploadFailure)
+			s.cleanup(fileName)
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// write uploads an object in one attempt. GCS reports most failures when the writer is closed, so the close
+// error is the outcome of the upload.
+func (s *Storage) write(ctx context.Context, fileName string, contentType string, token string, file []byte) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var metadata map[string]string
+	if token != "" {
+		metadata = map[string]string{tokenMetadata: token}
+	}
+	writer := s.FirebaseStorage.NewWriter(ctx, fileName, contentType, metadata)
+
+	if _, err := io.Copy(writer, bytes.NewReader(file)); err != nil {
+		// Cancelling before close aborts the upload instead of finalizing a truncated object
+		cancel()
+		_ = writer.Close()
+		return err
+	}
+
+	return writer.Close()
 }
 
-func (s *Storage) GenerateURL(data UploadImageData, uuid string) string {
-	url := fmt.Sprintf("https://firebasestorage.googleapis.com/v0/b/%s/o/%s?alt=media&token=%s", config.FirebaseStorageBucket, data.FileName, uuid)
-	strings.ReplaceAll(url, "@", "%40")
-	strings.ReplaceAll(url, ":", "%3A")
+// cleanup removes an object a failed upload may have left behind.
+func (s *Storage) cleanup(fileName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.UploadCleanupTimeout)
+	defer cancel()
 
-	return url
+	err := s.FirebaseStorage.Delete(ctx, fileName)
+	if err != nil && err != storage.ErrObjectNotExist {
+		log.Errorf("Cleanup of %s failed : %+v", fileName, err)
+		return
+	}
+	if err == nil {
+		countUpload(uploadCleanup)
+	}
+}
+
+// countUpload counts an upload outcome in the metrics.
+func countUpload(outcome string) {
+	uploadsTotal.Inc(outcome)
+}
+
+func isTransient(err error) bool {
+	if err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// Ping fails when the bucket is unreachable or access is denied.
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.FirebaseStorage.Ping(ctx)
+}
+
+// ThumbnailName is the object name of the thumbnail variant of an uploaded image.
+func ThumbnailName(fileName string) string {
+	return fileName + "_thumb"
+}
+
+// ExplanationName is the object name of the saliency overlay of an uploaded image.
+func ExplanationName(fileName string) string {
+	return fileName + "_explain.png"
+}
+
+// UploadExport uploads a data export without a download token, it is only reachable through SignedURL. Exports
+// are larger than images and have their own limit.
+func (s *Storage) UploadExport(data UploadImageData, contentType string) error {
+	if len(data.File) > config.MaxExportSize {
+		return ErrExportTooLarge
+	}
+	return s.upload(data.Ctx, data.FileName, contentType, "", data.File)
+}
+
+func (s *Storage) ReadImage(ctx context.Context, fileName string) ([]byte, error) {
+	reader, err := s.FirebaseStorage.NewReader(ctx, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+// DeleteObjects removes objects from the bucket, objects that do not exist are skipped.
+func (s *Storage) DeleteObjects(ctx context.Context, fileNames ...string) error {
+	for _, fileName := range fileNames {
+		err := s.FirebaseStorage.Delete(ctx, fileName)
+		if err != nil && err != storage.ErrObjectNotExist {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateURL builds the Firebase download URL of an object, which works for as long as token stays on the object.
+func (s *Storage) GenerateURL(fileName string, token string) string {
+	return fmt.Sprintf("https://firebasestorage.googleapis.com/v0/b/%s/o/%s?alt=media&token=%s",
+		url.PathEscape(config.FirebaseStorageBucket), url.PathEscape(fileName), url.QueryEscape(token))
+}
+
+// SignedURL returns a V4 signed URL for reading an object that stops working after expiry.
+func (s *Storage) SignedURL(fileName string, expiry time.Duration) (string, error) {
+	if s.signer == nil {
+		return "", ErrSigningUnavailable
+	}
+
+	return storage.SignedURL(config.FirebaseStorageBucket, fileName, &storage.SignedURLOptions{
+		GoogleAccessID: s.signer.ClientEmail,
+		PrivateKey:     []byte(s.signer.PrivateKey),
+		Method:         http.MethodGet,
+		Expires:        time.Now().Add(expiry),
+		Scheme:         storage.SigningSchemeV4,
+	})
+}
+
+// RotateToken replaces the download token of the objects with a new shared one, so previously issued download
+// URLs stop working. Objects that do not exist are skipped.
+func (s *Storage) RotateToken(ctx context.Context, fileNames ...string) (string, error) {
+	token := uuid.New().String()
+	err := s.updateMetadata(ctx, map[string]string{tokenMetadata: token}, fileNames)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RevokeToken removes the download token of the objects, after which they are only reachable through signed URLs.
+func (s *Storage) RevokeToken(ctx context.Context, fileNames ...string) error {
+	return s.updateMetadata(ctx, map[string]string{}, fileNames)
+}
+
+func (s *Storage) updateMetadata(ctx context.Context, metadata map[string]string, fileNames []string) error {
+	for _, fileName := range fileNames {
+		err := s.FirebaseStorage.UpdateMetadata(ctx, fileName, metadata)
+		if err == storage.ErrObjectNotExist {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
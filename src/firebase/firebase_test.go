@@ -0,0 +1,181 @@
+package firebase
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	"context"
+	"errors"
+	"github.com/hansels/sense_backend/config"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+type memObject struct {
+	data        []byte
+	contentType string
+	metadata    map[string]string
+}
+
+// memBucket keeps objects in memory. Writes to names in fail return failErr when closed, writes to names in errs
+// return the next of their errors until there are none left. A failed write leaves the object half written, as
+// an interrupted upload may.
+type memBucket struct {
+	mu      sync.Mutex
+	objects map[string]memObject
+	writes  map[string]int
+	fail    map[string]bool
+	failErr error
+	errs    map[string][]error
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{
+		objects: map[string]memObject{},
+		writes:  map[string]int{},
+		fail:    map[string]bool{},
+		failErr: errors.New("write failed"),
+		errs:    map[string][]error{},
+	}
+}
+
+type memWriter struct {
+	bytes.Buffer
+	bucket *memBucket
+	name   string
+	object memObject
+}
+
+func (w *memWriter) Close() error {
+	w.bucket.mu.Lock()
+	defer w.bucket.mu.Unlock()
+	w.bucket.writes[w.name]++
+
+	err := w.bucket.failErr
+	if !w.bucket.fail[w.name] {
+		err = nil
+		if errs := w.bucket.errs[w.name]; len(errs) > 0 {
+			err, w.bucket.errs[w.name] = errs[0], errs[1:]
+		}
+	}
+
+	w.object.data = w.Bytes()
+	if err != nil {
+		w.object.data = w.object.data[:w.Len()/2]
+	}
+	w.bucket.objects[w.name] = w.object
+	return err
+}
+
+func (b *memBucket) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) io.WriteCloser {
+	return &memWriter{bucket: b, name: name, object: memObject{contentType: contentType, metadata: metadata}}
+}
+
+func (b *memBucket) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	object, ok := b.objects[name]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(object.data)), nil
+}
+
+func (b *memBucket) Delete(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.objects[name]; !ok {
+		return storage.ErrObjectNotExist
+	}
+	delete(b.objects, name)
+	return nil
+}
+
+func (b *memBucket) UpdateMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	object, ok := b.objects[name]
+	if !ok {
+		return storage.ErrObjectNotExist
+	}
+	object.metadata = metadata
+	b.objects[name] = object
+	return nil
+}
+
+func (b *memBucket) Ping(ctx context.Context) error {
+	return nil
+}
+
+func TestUploadImage(t *testing.T) {
+	bucket := newMemBucket()
+	s := &Storage{FirebaseStorage: bucket}
+
+	uploaded, err := s.UploadImage(UploadImageData{Ctx: context.Background(), FileName: "image", File: encodeTestJPEG(t, halves(600, 300))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original, ok := bucket.objects["image"]
+	if !ok {
+		t.Fatal("image was not uploaded")
+	}
+	thumb, ok := bucket.objects[ThumbnailName("image")]
+	if !ok {
+		t.Fatal("thumbnail was not uploaded")
+	}
+
+	if original.contentType != "image/jpeg" || thumb.contentType != "image/jpeg" {
+		t.Errorf("content types are %q and %q", original.contentType, thumb.contentType)
+	}
+	token := original.metadata[tokenMetadata]
+	if token == "" || thumb.metadata[tokenMetadata] != token {
+		t.Errorf("download tokens are %q and %q, want one shared token", token, thumb.metadata[tokenMetadata])
+	}
+	if uploaded.URL != s.GenerateURL("image", token) || uploaded.ThumbnailURL != s.GenerateURL(ThumbnailName("image"), token) {
+		t.Errorf("urls are %s and %s", uploaded.URL, uploaded.ThumbnailURL)
+	}
+	if !bytes.Equal(uploaded.Data, original.data) {
+		t.Error("returned data is not what was uploaded")
+	}
+	if bounds := decodeTestJPEG(t, thumb.data).Bounds(); bounds.Dx() > config.ThumbnailSize || bounds.Dy() > config.ThumbnailSize {
+		t.Errorf("thumbnail is %dx%d", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestUploadImageRemovesOriginalWhenThumbnailFails(t *testing.T) {
+	bucket := newMemBucket()
+	bucket.fail[ThumbnailName("image")] = true
+	s := &Storage{FirebaseStorage: bucket}
+
+	_, err := s.UploadImage(UploadImageData{Ctx: context.Background(), FileName: "image", File: encodeTestJPEG(t, halves(32, 16))})
+	if err != bucket.failErr {
+		t.Fatalf("got %v, want %v", err, bucket.failErr)
+	}
+	if len(bucket.objects) != 0 {
+		t.Errorf("objects left behind: %v", bucket.objects)
+	}
+}
+
+func TestUploadImageRejectsInvalidImage(t *testing.T) {
+	bucket := newMemBucket()
+	s := &Storage{FirebaseStorage: bucket}
+
+	_, err := s.UploadImage(UploadImageData{Ctx: context.Background(), FileName: "image", File: []byte("not an image")})
+	if err != ErrInvalidImage {
+		t.Fatalf("got %v, want %v", err, ErrInvalidImage)
+	}
+	if len(bucket.objects) != 0 {
+		t.Errorf("objects uploaded: %v", bucket.objects)
+	}
+}
+
+func TestUploadFileTooLarge(t *testing.T) {
+	s := &Storage{FirebaseStorage: newMemBucket()}
+
+	_, err := s.UploadFile(UploadImageData{Ctx: context.Background(), FileName: "overlay", File: make([]byte, config.MaxUploadImageSize+1)}, "image/png")
+	if err != ErrImageTooLarge {
+		t.Errorf("got %v, want %v", err, ErrImageTooLarge)
+	}
+}
@@ -0,0 +1,211 @@
+package firebase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/hansels/sense_backend/config"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+var (
+	ErrImageTooLarge = errors.New("Image is too large")
+	ErrInvalidImage  = errors.New("Invalid image")
+)
+
+// NormalizedImage is an upload re-encoded as JPEG together with its thumbnail.
+type NormalizedImage struct {
+	Data      []byte
+	Thumbnail []byte
+}
+
+// NormalizeImage validates an uploaded image and re-encodes it as JPEG. Re-encoding drops EXIF (including
+// GPS) and any other metadata, so the EXIF orientation is applied to the pixels first.
+func NormalizeImage(data []byte) (*NormalizedImage, error) {
+	if len(data) > config.MaxUploadImageSize {
+		return nil, ErrImageTooLarge
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width*cfg.Height > config.MaxUploadImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	img := orient(toRGBA(decoded), exifOrientation(data))
+
+	normalized, err := encodeJPEG(img)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, err := encodeJPEG(thumbnail(img, config.ThumbnailSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &NormalizedImage{Data: normalized, Thumbnail: thumbnail}, nil
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: config.UploadImageQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// thumbnail downscales with an area average so the longest side is at most size.
+func thumbnail(img *image.RGBA, size int) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, (y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, (x+1)*w/tw
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				offset := img.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(img.Pix[offset+c])
+					}
+					offset += 4
+				}
+			}
+
+			count := (x1 - x0) * (y1 - y0)
+			offset := thumb.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				thumb.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return thumb
+}
+
+// orient transforms the pixels so the image displays upright without its EXIF orientation tag.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if orientation >= 5 {
+		w, h = h, w
+	}
+
+	oriented := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontal
+				sx, sy = w-1-x, y
+			case 3: // rotate 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertical
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate 90 clockwise
+				sx, sy = y, w-1-x
+			case 7: // transverse
+				sx, sy = h-1-y, w-1-x
+			case 8: // rotate 90 counter clockwise
+				sx, sy = h-1-y, x
+			}
+			copy(oriented.Pix[oriented.PixOffset(x, y):][:4], img.Pix[img.PixOffset(sx, sy):][:4])
+		}
+	}
+	return oriented
+}
+
+// exifOrientation reads the orientation tag from the EXIF segment of a JPEG, 1 (upright) when absent.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
@@ -0,0 +1,199 @@
+package firebase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/hansels/sense_backend/config"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{R: 255, A: 255}
+	blue = color.RGBA{B: 255, A: 255}
+)
+
+// halves is a w x h image, red on the left half and blue on the right.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := red
+			if x >= w/2 {
+				c = blue
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeTestJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withExif inserts an APP1 segment with the orientation tag and a GPS marker right after the SOI of a JPEG.
+func withExif(jpg []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = append(tiff, 0, 1)                         // one IFD entry
+	tiff = append(tiff, 0x01, 0x12, 0, 3, 0, 0, 0, 1) // orientation, SHORT, count 1
+	tiff = append(tiff, byte(orientation>>8), byte(orientation), 0, 0)
+	tiff = append(tiff, 0, 0, 0, 0) // no next IFD
+	tiff = append(tiff, []byte("GPS 52.3676N 4.9041E")...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, app1...)
+	return append(out, jpg[2:]...)
+}
+
+func decodeTestJPEG(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("output is not a JPEG: %v", err)
+	}
+	return img
+}
+
+func isReddish(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xC000 && b < 0x4000
+}
+
+func isBluish(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return b > 0xC000 && r < 0x4000
+}
+
+func TestNormalizeImageStripsExif(t *testing.T) {
+	data := withExif(encodeTestJPEG(t, halves(16, 8)), 1)
+	if exifOrientation(data) != 1 || !bytes.Contains(data, []byte("GPS")) {
+		t.Fatal("test image has no EXIF")
+	}
+
+	normalized, err := NormalizeImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range [][]byte{normalized.Data, normalized.Thumbnail} {
+		if bytes.Contains(out, []byte("Exif")) || bytes.Contains(out, []byte("GPS")) {
+			t.Error("EXIF survived normalization")
+		}
+	}
+}
+
+func TestNormalizeImageAppliesOrientation(t *testing.T) {
+	tests := []struct {
+		name        string
+		orientation uint16
+		width       int
+		height      int
+		// first and last are the pixels at the top left and bottom right
+		first, last func(color.Color) bool
+	}{
+		{name: "upright", orientation: 1, width: 32, height: 16, first: isReddish, last: isBluish},
+		{name: "rotate 180", orientation: 3, width: 32, height: 16, first: isBluish, last: isReddish},
+		{name: "rotate 90 clockwise", orientation: 6, width: 16, height: 32, first: isReddish, last: isBluish},
+		{name: "rotate 90 counter clockwise", orientation: 8, width: 16, height: 32, first: isBluish, last: isReddish},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalized, err := NormalizeImage(withExif(encodeTestJPEG(t, halves(32, 16)), tt.orientation))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img := decodeTestJPEG(t, normalized.Data)
+			bounds := img.Bounds()
+			if bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+				t.Fatalf("size is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+			}
+			if !tt.first(img.At(bounds.Min.X+1, bounds.Min.Y+1)) {
+				t.Errorf("top left is %v", img.At(bounds.Min.X+1, bounds.Min.Y+1))
+			}
+			if !tt.last(img.At(bounds.Max.X-2, bounds.Max.Y-2)) {
+				t.Errorf("bottom right is %v", img.At(bounds.Max.X-2, bounds.Max.Y-2))
+			}
+		})
+	}
+}
+
+func TestNormalizeImageThumbnail(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		thumbW        int
+		thumbH        int
+	}{
+		{name: "landscape", width: config.ThumbnailSize * 2, height: config.ThumbnailSize, thumbW: config.ThumbnailSize, thumbH: config.ThumbnailSize / 2},
+		{name: "portrait", width: config.ThumbnailSize, height: config.ThumbnailSize * 4, thumbW: config.ThumbnailSize / 4, thumbH: config.ThumbnailSize},
+		{name: "small", width: 20, height: 10, thumbW: 20, thumbH: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalized, err := NormalizeImage(encodeTestJPEG(t, halves(tt.width, tt.height)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bounds := decodeTestJPEG(t, normalized.Thumbnail).Bounds()
+			if bounds.Dx() != tt.thumbW || bounds.Dy() != tt.thumbH {
+				t.Errorf("thumbnail is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.thumbW, tt.thumbH)
+			}
+		})
+	}
+}
+
+// pngHeader is the start of a PNG of the given size, enough for image.DecodeConfig.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12] = 8 // bit depth
+	ihdr[13] = 2 // truecolor
+
+	out := []byte("\x89PNG\r\n\x1a\n")
+	out = append(out, 0, 0, 0, 13)
+	out = append(out, ihdr...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(ihdr))
+	return append(out, crc...)
+}
+
+func TestNormalizeImageLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "too many bytes", data: make([]byte, config.MaxUploadImageSize+1), want: ErrImageTooLarge},
+		{name: "too many pixels", data: pngHeader(20000, 20000), want: ErrImageTooLarge},
+		{name: "not an image", data: []byte("not an image"), want: ErrInvalidImage},
+		{name: "truncated", data: pngHeader(10, 10), want: ErrInvalidImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NormalizeImage(tt.data)
+			if err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
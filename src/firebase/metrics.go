@@ -0,0 +1,59 @@
+package firebase
+
+import (
+	"context"
+	"github.com/hansels/sense_backend/common/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"io"
+	"path"
+	"sync"
+	"time"
+)
+
+var firestoreDuration = metrics.NewHistogram("sense_firestore_request_duration_seconds",
+	"Time Firestore took to answer, by RPC method and status code.", nil, "method", "code")
+
+// timeUnary times unary Firestore calls, e.g. the commits of Set, Update and Delete.
+func timeUnary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	t := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	observeFirestore(method, t, err)
+	return err
+}
+
+// timeStream times streaming Firestore calls, e.g. the reads of Get and Documents, up to their first response.
+// Callers often stop reading before the stream ends, so that is the latency that is always known.
+func timeStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	t := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		observeFirestore(method, t, err)
+		return nil, err
+	}
+	return &timedStream{ClientStream: stream, method: method, start: t}, nil
+}
+
+type timedStream struct {
+	grpc.ClientStream
+
+	method string
+	start  time.Time
+	once   sync.Once
+}
+
+func (s *timedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	s.once.Do(func() {
+		outcome := err
+		if outcome == io.EOF {
+			outcome = nil
+		}
+		observeFirestore(s.method, s.start, outcome)
+	})
+	return err
+}
+
+func observeFirestore(method string, start time.Time, err error) {
+	firestoreDuration.Observe(time.Since(start).Seconds(), path.Base(method), status.Code(err).String())
+}
@@ -0,0 +1,187 @@
+package firebase
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hansels/sense_backend/common/metrics"
+	"github.com/hansels/sense_backend/config"
+	"google.golang.org/api/googleapi"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, want: true},
+		{name: "too many requests", err: &googleapi.Error{Code: http.StatusTooManyRequests}, want: true},
+		{name: "internal error", err: &googleapi.Error{Code: http.StatusInternalServerError}, want: true},
+		{name: "service unavailable", err: &googleapi.Error{Code: http.StatusServiceUnavailable}, want: true},
+		{name: "wrapped service unavailable", err: fmt.Errorf("close: %w", &googleapi.Error{Code: http.StatusServiceUnavailable}), want: true},
+		{name: "network timeout", err: timeoutError{}, want: true},
+		{name: "forbidden", err: &googleapi.Error{Code: http.StatusForbidden}, want: false},
+		{name: "not found", err: &googleapi.Error{Code: http.StatusNotFound}, want: false},
+		{name: "other", err: errors.New("boom"), want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("%s: isTransient = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// uploadCounts reads sense_storage_uploads_total by outcome from the metrics output.
+func uploadCounts(t *testing.T) map[string]float64 {
+	t.Helper()
+
+	var buf bytes.Buffer
+	metrics.WriteAll(&buf)
+
+	counts := map[string]float64{}
+	prefix := `sense_storage_uploads_total{outcome="`
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, prefix))
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Fatalf("bad metric line %q", line)
+		}
+		counts[strings.TrimSuffix(fields[0], `"}`)] = value
+	}
+	return counts
+}
+
+// countsSince is what the upload outcomes went up by since before.
+func countsSince(t *testing.T, before map[string]float64) map[string]float64 {
+	t.Helper()
+
+	delta := map[string]float64{}
+	for outcome, value := range uploadCounts(t) {
+		if d := value - before[outcome]; d != 0 {
+			delta[outcome] = d
+		}
+	}
+	return delta
+}
+
+func assertCounts(t *testing.T, got map[string]float64, want map[string]float64) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("upload outcomes are %v, want %v", got, want)
+		return
+	}
+	for outcome, value := range want {
+		if got[outcome] != value {
+			t.Errorf("upload outcomes are %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+func TestUploadRetriesTransientErrors(t *testing.T) {
+	bucket := newMemBucket()
+	bucket.errs["overlay"] = []error{&googleapi.Error{Code: http.StatusServiceUnavailable}, io.ErrUnexpectedEOF}
+	s := &Storage{FirebaseStorage: bucket}
+	before := uploadCounts(t)
+
+	_, err := s.UploadFile(UploadImageData{Ctx: context.Background(), FileName: "overlay", File: []byte("overlay data")}, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bucket.writes["overlay"] != 3 {
+		t.Errorf("took %d attempts, want 3", bucket.writes["overlay"])
+	}
+	if got := string(bucket.objects["overlay"].data); got != "overlay data" {
+		t.Errorf("stored %q", got)
+	}
+	assertCounts(t, countsSince(t, before), map[string]float64{uploadRetry: 2, uploadSuccess: 1})
+}
+
+func TestUploadGivesUpAfterRetries(t *testing.T) {
+	bucket := newMemBucket()
+	for i := 0; i < config.UploadRetries; i++ {
+		bucket.errs["overlay"] = append(bucket.errs["overlay"], &googleapi.Error{Code: http.StatusServiceUnavailable})
+	}
+	s := &Storage{FirebaseStorage: bucket}
+	before := uploadCounts(t)
+
+	_, err := s.UploadFile(UploadImageData{Ctx: context.Background(), FileName: "overlay", File: []byte("overlay data")}, "image/png")
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want the last upload error", err)
+	}
+
+	if bucket.writes["overlay"] != config.UploadRetries {
+		t.Errorf("took %d attempts, want %d", bucket.writes["overlay"], config.UploadRetries)
+	}
+	if len(bucket.objects) != 0 {
+		t.Errorf("objects left behind: %v", bucket.objects)
+	}
+	assertCounts(t, countsSince(t, before), map[string]float64{
+		uploadRetry:   float64(config.UploadRetries - 1),
+		uploadFailure: 1,
+		uploadCleanup: 1,
+	})
+}
+
+func TestUploadDoesNotRetryPermanentErrors(t *testing.T) {
+	bucket := newMemBucket()
+	bucket.errs["overlay"] = []error{&googleapi.Error{Code: http.StatusForbidden}}
+	s := &Storage{FirebaseStorage: bucket}
+	before := uploadCounts(t)
+
+	_, err := s.UploadFile(UploadImageData{Ctx: context.Background(), FileName: "overlay", File: []byte("overlay data")}, "image/png")
+	if err == nil {
+		t.Fatal("upload succeeded")
+	}
+
+	if bucket.writes["overlay"] != 1 {
+		t.Errorf("took %d attempts, want 1", bucket.writes["overlay"])
+	}
+	if len(bucket.objects) != 0 {
+		t.Errorf("objects left behind: %v", bucket.objects)
+	}
+	assertCounts(t, countsSince(t, before), map[string]float64{uploadFailure: 1, uploadCleanup: 1})
+}
+
+func TestUploadStopsRetryingWhenCancelled(t *testing.T) {
+	bucket := newMemBucket()
+	bucket.errs["overlay"] = []error{io.ErrUnexpectedEOF, io.ErrUnexpectedEOF}
+	s := &Storage{FirebaseStorage: bucket}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.UploadFile(UploadImageData{Ctx: ctx, FileName: "overlay", File: []byte("overlay data")}, "image/png")
+	if err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if bucket.writes["overlay"] != 1 {
+		t.Errorf("took %d attempts, want 1", bucket.writes["overlay"])
+	}
+	if len(bucket.objects) != 0 {
+		t.Errorf("objects left behind: %v", bucket.objects)
+	}
+}
@@ -0,0 +1,195 @@
+package ml
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	tf "github.com/galeone/tensorflow/tensorflow/go"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+const (
+	inputSize       = 224
+	occlusionPatch  = 28
+	occlusionStride = 28
+	occlusionFill   = float32(0.5)
+	overlayOpacity  = 0.6
+)
+
+// Saliency is an occlusion sensitivity map of the model input for the label
+// the model is most confident about.
+type Saliency struct {
+	Label   string
+	Score   float32
+	Heatmap [][]float32
+	Overlay []byte
+}
+
+// Explain slides a gray patch over the 224x224 input and measures how much the
+// score of the top label drops for each position. The resulting heatmap is
+// normalized to [0, 1] and rendered as a PNG overlay on top of the input.
+// It stops between inferences once ctx is done.
+func (c *Coco) Explain(ctx context.Context, data []byte) (saliency *Saliency, err error) {
+	done := track(operationExplain)
+	defer func() { done(err) }()
+
+	tensor, err := makeTensorFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, ok := tensor.Value().([][][][]float32)
+	if !ok || len(batch) != 1 {
+		return nil, fmt.Errorf("Unexpected input tensor shape: %v", tensor.Shape())
+	}
+	input := batch[0]
+
+	scores := c.scores(tensor)
+	target := 0
+	for i, score := range scores {
+		if score > scores[target] {
+			target = i
+		}
+	}
+	base := scores[target]
+
+	grid := (inputSize-occlusionPatch)/occlusionStride + 1
+	heatmap := make([][]float32, grid)
+	var max float32
+	for gy := 0; gy < grid; gy++ {
+		heatmap[gy] = make([]float32, grid)
+		for gx := 0; gx < grid; gx++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			restore := occlude(input, gx*occlusionStride, gy*occlusionStride)
+			occluded, err := tf.NewTensor(batch)
+			restore()
+			if err != nil {
+				return nil, err
+			}
+
+			drop := base - c.scores(occluded)[target]
+			if drop < 0 {
+				drop = 0
+			}
+			heatmap[gy][gx] = drop
+			if drop > max {
+				max = drop
+			}
+		}
+	}
+
+	if max > 0 {
+		for _, row := range heatmap {
+			for i := range row {
+				row[i] /= max
+			}
+		}
+	}
+
+	overlay, err := renderOverlay(input, heatmap)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Saliency{Label: c.labels[target], Score: base, Heatmap: heatmap, Overlay: overlay}, nil
+}
+
+// scores runs the model and returns the score of every label.
+func (c *Coco) scores(tensor *tf.Tensor) []float32 {
+	output := c.model.Exec(
+		[]tf.Output{
+			c.model.Op("StatefulPartitionedCall", 0),
+		},
+		map[tf.Output]*tf.Tensor{
+			c.model.Op("serving_default_input_1", 0): tensor,
+		},
+	)
+
+	return output[0].Value().([][]float32)[0]
+}
+
+// occlude fills the patch starting at (x, y) with a neutral color and returns
+// a function that puts the original pixels back.
+func occlude(input [][][]float32, x, y int) func() {
+	saved := make([][3]float32, 0, occlusionPatch*occlusionPatch)
+	for py := y; py < y+occlusionPatch; py++ {
+		for px := x; px < x+occlusionPatch; px++ {
+			pixel := input[py][px]
+			saved = append(saved, [3]float32{pixel[0], pixel[1], pixel[2]})
+			pixel[0], pixel[1], pixel[2] = occlusionFill, occlusionFill, occlusionFill
+		}
+	}
+
+	return func() {
+		i := 0
+		for py := y; py < y+occlusionPatch; py++ {
+			for px := x; px < x+occlusionPatch; px++ {
+				copy(input[py][px], saved[i][:])
+				i++
+			}
+		}
+	}
+}
+
+// renderOverlay blends the upsampled heatmap over the normalized input image
+// using a jet color map and encodes the result as PNG.
+func renderOverlay(input [][][]float32, heatmap [][]float32) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, inputSize, inputSize))
+	for y := 0; y < inputSize; y++ {
+		for x := 0; x < inputSize; x++ {
+			heat := sampleHeatmap(heatmap, x, y)
+			alpha := overlayOpacity * heat
+			r, g, b := jet(heat)
+
+			pixel := input[y][x]
+			img.SetRGBA(x, y, color.RGBA{
+				R: toByte((1-alpha)*float64(pixel[0]) + alpha*r),
+				G: toByte((1-alpha)*float64(pixel[1]) + alpha*g),
+				B: toByte((1-alpha)*float64(pixel[2]) + alpha*b),
+				A: 255,
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sampleHeatmap bilinearly interpolates the heatmap between patch centers.
+func sampleHeatmap(heatmap [][]float32, x, y int) float64 {
+	last := float64(len(heatmap) - 1)
+	fx := clamp((float64(x)+0.5-occlusionPatch/2)/occlusionStride, 0, last)
+	fy := clamp((float64(y)+0.5-occlusionPatch/2)/occlusionStride, 0, last)
+
+	x0, y0 := int(fx), int(fy)
+	x1, y1 := int(math.Min(float64(x0+1), last)), int(math.Min(float64(y0+1), last))
+	dx, dy := fx-float64(x0), fy-float64(y0)
+
+	top := float64(heatmap[y0][x0])*(1-dx) + float64(heatmap[y0][x1])*dx
+	bottom := float64(heatmap[y1][x0])*(1-dx) + float64(heatmap[y1][x1])*dx
+	return top*(1-dy) + bottom*dy
+}
+
+func jet(v float64) (r, g, b float64) {
+	r = clamp(1.5-math.Abs(4*v-3), 0, 1)
+	g = clamp(1.5-math.Abs(4*v-2), 0, 1)
+	b = clamp(1.5-math.Abs(4*v-1), 0, 1)
+	return r, g, b
+}
+
+func toByte(v float64) uint8 {
+	return uint8(clamp(v, 0, 1)*255 + 0.5)
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
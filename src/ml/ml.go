@@ -2,15 +2,21 @@ package ml
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	tf "github.com/galeone/tensorflow/tensorflow/go"
 	"github.com/galeone/tensorflow/tensorflow/go/op"
 	tg "github.com/galeone/tfgo"
+	"github.com/hansels/sense_backend/common/metrics"
 	"image"
+	"image/jpeg"
 	"io"
 	"io/ioutil"
 	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Coco struct {
@@ -18,6 +24,41 @@ type Coco struct {
 	labels []string
 }
 
+var (
+	inferenceBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+	inferenceDuration = metrics.NewHistogram("sense_inference_duration_seconds", "Time the model took, by operation.", inferenceBuckets, "operation")
+	inferenceErrors   = metrics.NewCounter("sense_inference_errors_total", "Failed inferences, by operation.", "operation")
+	inferenceQueue    = metrics.NewGauge("sense_inference_queue_depth", "Inferences running or waiting for the model, by operation.", "operation")
+)
+
+// ErrNotLoaded is returned by WarmUp before Load.
+var ErrNotLoaded = errors.New("Model is not loaded")
+
+var (
+	warmUpOnce  sync.Once
+	warmUpImage []byte
+	warmUpErr   error
+)
+
+const (
+	operationPredict = "predict"
+	operationExplain = "explain"
+)
+
+// track counts an inference in the queue until the returned func records how it went.
+func track(operation string) func(err error) {
+	t := time.Now()
+	inferenceQueue.Inc(operation)
+	return func(err error) {
+		inferenceQueue.Dec(operation)
+		inferenceDuration.Observe(time.Since(t).Seconds(), operation)
+		if err != nil {
+			inferenceErrors.Inc(operation)
+		}
+	}
+}
+
 const path = "files/models/my_model/"
 
 // NewCoco returns a Coco object
@@ -47,9 +88,55 @@ func (c *Coco) Load() error {
 	return nil
 }
 
-// Predict predicts.
-func (c *Coco) Predict(data []byte) *ObjectDetectionResponse {
-	tensor, _ := makeTensorFromBytes(data)
+// Loaded reports whether Load succeeded.
+func (c *Coco) Loaded() bool {
+	return c.model != nil && len(c.labels) > 0
+}
+
+// WarmUp runs an inference on a blank image, which only succeeds when the model can serve predictions.
+func (c *Coco) WarmUp(ctx context.Context) error {
+	if !c.Loaded() {
+		return ErrNotLoaded
+	}
+
+	warmUpOnce.Do(func() {
+		var buf bytes.Buffer
+		blank := image.NewGray(image.Rect(0, 0, inputSize, inputSize))
+		warmUpErr = jpeg.Encode(&buf, blank, nil)
+		warmUpImage = buf.Bytes()
+	})
+	if warmUpErr != nil {
+		return warmUpErr
+	}
+
+	_, err := c.Predict(ctx, warmUpImage)
+	return err
+}
+
+// Labels returns the labels the model can predict.
+func (c *Coco) Labels() []string {
+	labels := make([]string, 0, len(c.labels))
+	for _, label := range c.labels {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// Predict predicts. A running inference cannot be interrupted, so ctx is only checked before it starts.
+func (c *Coco) Predict(ctx context.Context, data []byte) (outcome *ObjectDetectionResponse, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	done := track(operationPredict)
+	defer func() { done(err) }()
+
+	tensor, err := makeTensorFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
 
 	output := c.model.Exec(
 		[]tf.Output{
@@ -60,8 +147,8 @@ func (c *Coco) Predict(data []byte) *ObjectDetectionResponse {
 		},
 	)
 
-	outcome := NewObjectDetectionResponse(output, c.labels)
-	return outcome
+	outcome = NewObjectDetectionResponse(output, c.labels)
+	return outcome, nil
 }
 
 // Convert the image in filename to a Tensor suitable as input
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// APIKey lets a service call the API without a user. Only the hash of the key is stored; Scopes lists the route
+// paths it may call, a path ending in /* covers everything below it.
+type APIKey struct {
+	ID         string    `json:"id" structs:"id"`
+	Name       string    `json:"name" structs:"name"`
+	Hash       string    `json:"hash,omitempty" structs:"hash"`
+	Scopes     []string  `json:"scopes" structs:"scopes"`
+	CreatedBy  string    `json:"created_by" structs:"created_by"`
+	CreatedAt  time.Time `json:"created_at" structs:"created_at,omitnested"`
+	ExpiresAt  time.Time `json:"expires_at" structs:"expires_at,omitnested"`
+	LastUsedAt time.Time `json:"last_used_at" structs:"last_used_at,omitnested"`
+}
+
+type CreateAPIKeyData struct {
+	Name          string   `json:"name" structs:"name"`
+	Scopes        []string `json:"scopes" structs:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days" structs:"expires_in_days"`
+}
+
+// CreatedAPIKey carries the plain key, which is only ever returned once.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key" structs:"key"`
+}
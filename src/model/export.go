@@ -0,0 +1,50 @@
+package model
+
+import "time"
+
+const (
+	ExportFormatJSON = "json"
+	ExportFormatZip  = "zip"
+)
+
+type UserReview struct {
+	Resort string `json:"resort" structs:"resort"`
+	Review Review `json:"review" structs:"review"`
+}
+
+type ImageReference struct {
+	ID          string `json:"id" structs:"id"`
+	Image       string `json:"image,omitempty" structs:"image"`
+	Thumbnail   string `json:"thumbnail,omitempty" structs:"thumbnail"`
+	Explanation string `json:"explanation,omitempty" structs:"explanation"`
+}
+
+type UserExport struct {
+	GeneratedAt time.Time            `json:"generated_at" structs:"generated_at,omitnested"`
+	Profile     *User                `json:"profile" structs:"profile"`
+	Predictions []Prediction         `json:"predictions" structs:"predictions"`
+	Jobs        []PredictionJob      `json:"prediction_jobs" structs:"prediction_jobs"`
+	Feedback    []PredictionFeedback `json:"feedback" structs:"feedback"`
+	Reviews     []UserReview         `json:"reviews" structs:"reviews"`
+	AuditLogs   []AuditLog           `json:"audit_logs" structs:"audit_logs"`
+	Images      []ImageReference     `json:"images" structs:"images"`
+}
+
+type DataExport struct {
+	ID        string    `json:"id" structs:"id"`
+	UserID    string    `json:"user_id" structs:"user_id"`
+	Format    string    `json:"format" structs:"format"`
+	Status    string    `json:"status" structs:"status"`
+	Error     string    `json:"error,omitempty" structs:"error,omitempty"`
+	URL       string    `json:"url,omitempty" structs:"-"`
+	CreatedAt time.Time `json:"created_at" structs:"created_at,omitnested"`
+	UpdatedAt time.Time `json:"updated_at" structs:"updated_at,omitnested"`
+}
+
+type AuditLog struct {
+	UserID    string            `json:"user_id" structs:"user_id"`
+	Action    string            `json:"action" structs:"action"`
+	IP        string            `json:"ip,omitempty" structs:"ip,omitempty"`
+	Details   map[string]string `json:"details,omitempty" structs:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at" structs:"created_at,omitnested"`
+}
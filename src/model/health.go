@@ -0,0 +1,23 @@
+package model
+
+const (
+	HealthStatusOK     = "ok"
+	HealthStatusFailed = "failed"
+
+	ReadinessReady        = "ready"
+	ReadinessNotReady     = "not_ready"
+	ReadinessShuttingDown = "shutting_down"
+)
+
+// DependencyCheck is the outcome of checking one dependency the API cannot serve without. Why a check failed is
+// only logged.
+type DependencyCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Latency string `json:"latency,omitempty"`
+}
+
+type Readiness struct {
+	Status string            `json:"status"`
+	Checks []DependencyCheck `json:"checks,omitempty"`
+}
@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+// PredictionJob is read back by whoever holds its token, which is only returned when the job is created. Only the
+// hash of the token is stored.
+type PredictionJob struct {
+	ID        string            `json:"id" structs:"id"`
+	Status    string            `json:"status" structs:"status"`
+	UserID    string            `json:"user_id,omitempty" structs:"user_id"`
+	Token     string            `json:"token,omitempty" structs:"-"`
+	TokenHash string            `json:"token_hash,omitempty" structs:"token_hash"`
+	Image     string            `json:"image" structs:"image"`
+	Thumbnail string            `json:"thumbnail" structs:"thumbnail"`
+	Explain   bool              `json:"explain" structs:"explain"`
+	Webhook   string            `json:"webhook,omitempty" structs:"webhook,omitempty"`
+	Result    *PredictionResult `json:"result,omitempty" structs:"result,omitempty"`
+	Error     string            `json:"error,omitempty" structs:"error,omitempty"`
+	CreatedAt time.Time         `json:"created_at" structs:"created_at,omitnested"`
+	UpdatedAt time.Time         `json:"updated_at" structs:"updated_at,omitnested"`
+}
+
+// Public returns a copy without the owner and the token hash, to send to clients and webhooks. UserID keeps its
+// json tag because jobs are decoded from Firestore through JSON.
+func (j PredictionJob) Public() *PredictionJob {
+	j.UserID = ""
+	j.TokenHash = ""
+	return &j
+}
@@ -1,7 +1,44 @@
 package model
 
+import "time"
+
 type PredictionResult struct {
-	Verdict    string `json:"verdict" structs:"verdict"`
-	IsDetected bool   `json:"is_detected" structs:"is_detected"`
-	Image      string `json:"image" structs:"image"`
+	ID          string `json:"id" structs:"id"`
+	Verdict     string `json:"verdict" structs:"verdict"`
+	IsDetected  bool   `json:"is_detected" structs:"is_detected"`
+	Image       string `json:"image" structs:"image"`
+	Thumbnail   string `json:"thumbnail" structs:"thumbnail"`
+	Explanation string `json:"explanation,omitempty" structs:"explanation,omitempty"`
+}
+
+type BatchPredictionItem struct {
+	Name      string            `json:"name" structs:"name"`
+	Result    *PredictionResult `json:"result,omitempty" structs:"result,omitempty"`
+	Error     string            `json:"error,omitempty" structs:"error,omitempty"`
+	ErrorCode string            `json:"error_code,omitempty" structs:"error_code,omitempty"`
+}
+
+type Prediction struct {
+	ID          string    `json:"id" structs:"id"`
+	UserID      string    `json:"user_id,omitempty" structs:"user_id"`
+	Verdict     string    `json:"verdict" structs:"verdict"`
+	IsDetected  bool      `json:"is_detected" structs:"is_detected"`
+	Image       string    `json:"image" structs:"image"`
+	Thumbnail   string    `json:"thumbnail" structs:"thumbnail"`
+	Explanation string    `json:"explanation,omitempty" structs:"explanation,omitempty"`
+	CreatedAt   time.Time `json:"created_at" structs:"created_at,omitnested"`
+}
+
+type PredictionFeedback struct {
+	PredictionID string    `json:"prediction_id" structs:"prediction_id"`
+	UserID       string    `json:"user_id" structs:"user_id"`
+	Label        string    `json:"label" structs:"label"`
+	Verdict      string    `json:"verdict" structs:"verdict"`
+	Correct      bool      `json:"correct" structs:"correct"`
+	Image        string    `json:"image" structs:"image"`
+	CreatedAt    time.Time `json:"created_at" structs:"created_at,omitnested"`
+}
+
+type FeedbackData struct {
+	Label string `json:"label" structs:"label"`
 }
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+type ExpiredPrediction struct {
+	ID            string    `json:"id" structs:"id"`
+	UserID        string    `json:"user_id,omitempty" structs:"user_id"`
+	CreatedAt     time.Time `json:"created_at" structs:"created_at,omitnested"`
+	RetentionDays int       `json:"retention_days" structs:"retention_days"`
+}
+
+type RetentionReport struct {
+	GlobalRetentionDays int                 `json:"global_retention_days" structs:"global_retention_days"`
+	UserRetentionDays   map[string]int      `json:"user_retention_days" structs:"user_retention_days"`
+	Expired             []ExpiredPrediction `json:"expired" structs:"expired"`
+	Total               int                 `json:"total" structs:"total"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// TwoFactor is the TOTP enrollment of a user. Recovery codes are stored hashed, LastStep is the last accepted
+// time step so a code cannot be replayed.
+type TwoFactor struct {
+	UserID        string    `json:"user_id" structs:"user_id"`
+	Secret        string    `json:"secret" structs:"secret"`
+	Enabled       bool      `json:"enabled" structs:"enabled"`
+	RecoveryCodes []string  `json:"recovery_codes" structs:"recovery_codes"`
+	LastStep      int64     `json:"last_step" structs:"last_step"`
+	UpdatedAt     time.Time `json:"updated_at" structs:"updated_at,omitnested"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret" structs:"secret"`
+	URI    string `json:"otpauth_uri" structs:"otpauth_uri"`
+}
+
+type TwoFactorCodeData struct {
+	Code string `json:"code" structs:"code"`
+}
+
+type TwoFactorLoginData struct {
+	ChallengeToken string `json:"challenge_token" structs:"challenge_token"`
+	Code           string `json:"code" structs:"code"`
+}
+
+type DisableTwoFactorData struct {
+	Password string `json:"password" structs:"password"`
+	Code     string `json:"code" structs:"code"`
+}
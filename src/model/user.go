@@ -1,10 +1,20 @@
 package model
 
+const (
+	UserTypeMember = "Member"
+	UserTypeAdmin  = "Admin"
+)
+
 type User struct {
-	Name     string `json:"name" structs:"name"`
-	Email    string `json:"email" structs:"email"`
-	Password string `json:"password,omitempty" structs:"password"`
-	Type     string `json:"type" structs:"type"`
+	Name             string `json:"name" structs:"name"`
+	Email            string `json:"email" structs:"email"`
+	Password         string `json:"password,omitempty" structs:"password"`
+	Type             string `json:"type" structs:"type"`
+	RetentionDays    int    `json:"retention_days,omitempty" structs:"retention_days,omitempty"`
+	Photo            string `json:"photo,omitempty" structs:"photo,omitempty"`
+	PhotoID          string `json:"photo_id,omitempty" structs:"photo_id,omitempty"`
+	FirebaseUID      string `json:"firebase_uid,omitempty" structs:"firebase_uid,omitempty"`
+	TokensValidAfter int64  `json:"tokens_valid_after,omitempty" structs:"tokens_valid_after,omitempty"`
 }
 
 type LoginData struct {
@@ -12,6 +22,24 @@ type LoginData struct {
 	Password string `json:"password" structs:"password"`
 }
 
+type FirebaseLoginData struct {
+	IDToken string `json:"id_token" structs:"id_token"`
+}
+
 type CheckUserData struct {
 	Email string `json:"email" structs:"email"`
 }
+
+type RetentionData struct {
+	Days int `json:"days" structs:"days"`
+}
+
+type ProfileData struct {
+	Name  *string `json:"name" structs:"name"`
+	Photo []byte  `json:"-" structs:"-"`
+}
+
+type ChangePasswordData struct {
+	CurrentPassword string `json:"current_password" structs:"current_password"`
+	NewPassword     string `json:"new_password" structs:"new_password"`
+}
@@ -0,0 +1,214 @@
+package sense
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"github.com/fatih/structs"
+	"github.com/google/uuid"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/errors"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/src/firebase"
+	"github.com/hansels/sense_backend/src/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+const resortsCollection = "resorts"
+
+// GetProfile returns the user without the password hash.
+func (m *Module) GetProfile(ctx context.Context, userID string) (*model.User, error) {
+	user, err := m.GetUser(ctx, userID)
+	if status.Code(err) == codes.NotFound {
+		return nil, custerr.ErrChain{Message: "User not found", Cause: err, Type: response.ErrNotFound}
+	}
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read user failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	// Never send password to FE (it's dangerous)
+	user.Password = ""
+	return user, nil
+}
+
+// UpdateProfile changes the name and replaces the profile photo of a user, fields left nil are kept.
+func (m *Module) UpdateProfile(ctx context.Context, userID string, profile model.ProfileData) (*model.User, error) {
+	user, err := m.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []firestore.Update
+	if profile.Name != nil {
+		name := strings.TrimSpace(*profile.Name)
+		if name == "" {
+			return nil, ValidationError("name", response.DetailRequired, "Name must not be empty")
+		}
+		user.Name = name
+		updates = append(updates, firestore.Update{Path: "name", Value: name})
+	}
+
+	oldPhotoID := user.PhotoID
+	if profile.Photo != nil {
+		photoID := "profile_" + uuid.New().String()
+		uploaded, err := m.Storage.UploadImage(firebase.UploadImageData{Ctx: ctx, FileName: photoID, File: profile.Photo})
+		if errors.Is(err, firebase.ErrImageTooLarge) || errors.Is(err, firebase.ErrInvalidImage) {
+			return nil, imageError(err, "photo")
+		}
+		if err != nil {
+			return nil, custerr.ErrChain{Message: "Upload photo failed", Cause: err, Type: response.ErrInternalServerError}
+		}
+
+		user.Photo = uploaded.URL
+		user.PhotoID = photoID
+		updates = append(updates,
+			firestore.Update{Path: "photo", Value: uploaded.URL},
+			firestore.Update{Path: "photo_id", Value: photoID})
+	}
+
+	if len(updates) == 0 {
+		return user, nil
+	}
+
+	_, err = m.Firestore.Collection(usersCollection).Doc(userID).Update(ctx, updates)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+		return nil, custerr.ErrChain{Message: "Save profile failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	if profile.Photo != nil && oldPhotoID != "" {
+		m.deletePhoto(ctx, oldPhotoID)
+	}
+	return user, nil
+}
+
+// ChangePassword replaces the password after verifying the current one. Wrong passwords count against the account
+// and the client IP like failed logins, so a stolen session cannot be used to guess the password.
+func (m *Module) ChangePassword(ctx context.Context, userID string, current string, next string, ip string) error {
+	accountKey, ipKey := AccountKey(userID), IPKey(ip)
+	if wait := m.LoginGuard.Wait(accountKey, ipKey); wait > 0 {
+		return tooManyAttempts(wait)
+	}
+
+	user, err := m.GetUser(ctx, userID)
+	if err != nil {
+		return custerr.ErrChain{Message: "User not found", Cause: err, Type: response.ErrNotFound}
+	}
+
+	err = CheckPassword(user.Password, current)
+	if err != nil {
+		m.LoginGuard.Fail(accountKey, AccountGuardPolicy)
+		m.LoginGuard.Fail(ipKey, IPGuardPolicy)
+		return custerr.ErrChain{Message: "Current password is incorrect", Type: response.ErrForbiddenResource}
+	}
+	m.LoginGuard.Reset(accountKey)
+
+	if next == "" {
+		return ValidationError("new_password", response.DetailRequired, "New password must not be empty")
+	}
+
+	password, err := HashPassword(next)
+	if err != nil {
+		return custerr.ErrChain{Message: "Hash password failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	// Revoke the tokens issued with the old password
+	_, err = m.Firestore.Collection(usersCollection).Doc(userID).Update(ctx, []firestore.Update{
+		{Path: "password", Value: password},
+		{Path: "tokens_valid_after", Value: time.Now().Unix()},
+	})
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+		return custerr.ErrChain{Message: "Save password failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+// DeleteAccount removes the user together with their predictions, prediction jobs, stored images, reviews, data
+// exports, two-factor settings and profile photo. Tokens of the user stop working with the user, and do not come
+// back when the email registers again as new users only accept tokens issued after they were created.
+func (m *Module) DeleteAccount(ctx context.Context, userID string) error {
+	user, err := m.GetProfile(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DeleteUserImages(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = m.deleteUserJobs(ctx, userID)
+	if err != nil {
+		return custerr.ErrChain{Message: "Delete prediction jobs failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	err = m.deleteUserReviews(ctx, userID)
+	if err != nil {
+		return custerr.ErrChain{Message: "Delete reviews failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	err = m.deleteUserExports(ctx, userID)
+	if err != nil {
+		return custerr.ErrChain{Message: "Delete exports failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	err = m.deleteTwoFactor(ctx, userID)
+	if err != nil {
+		return custerr.ErrChain{Message: "Delete two-factor settings failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	if user.PhotoID != "" {
+		m.deletePhoto(ctx, user.PhotoID)
+	}
+
+	_, err = m.Firestore.Collection(usersCollection).Doc(userID).Delete(ctx)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Delete from Firestore error : %+v", err)
+		return custerr.ErrChain{Message: "Delete user failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+// deleteUserReviews strips the reviews written by the user from every resort.
+func (m *Module) deleteUserReviews(ctx context.Context, userID string) error {
+	docs, err := m.Firestore.Collection(resortsCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		var resort model.Resort
+		err = decodeDocument(doc.Data(), &resort)
+		if err != nil {
+			log.WithContext(ctx).Errorf("Resort Unmarshal Error : %+v", err)
+			continue
+		}
+
+		reviews := make([]interface{}, 0, len(resort.Reviews))
+		for _, review := range resort.Reviews {
+			if review.User.Email != userID {
+				reviews = append(reviews, structs.Map(review))
+			}
+		}
+		if len(reviews) == len(resort.Reviews) {
+			continue
+		}
+
+		_, err = doc.Ref.Update(ctx, []firestore.Update{{Path: "reviews", Value: reviews}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Module) deletePhoto(ctx context.Context, photoID string) {
+	err := m.Storage.DeleteObjects(ctx, photoID, firebase.ThumbnailName(photoID))
+	if err != nil {
+		log.WithContext(ctx).Errorf("Delete Photo %s Error : %+v", photoID, err)
+	}
+}
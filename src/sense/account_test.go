@@ -0,0 +1,119 @@
+package sense
+
+import (
+	"context"
+	"github.com/fatih/structs"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/model"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newPasswordModule(t *testing.T, email string, password string) *Module {
+	t.Helper()
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Module{Firestore: newTestFirestore(t), LoginGuard: NewLoginGuard()}
+	user := model.User{Email: email, Password: hash}
+	_, err = m.Firestore.Collection(usersCollection).Doc(email).Set(context.Background(), structs.Map(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestChangePasswordThrottlesWrongPasswords(t *testing.T) {
+	ctx := context.Background()
+	m := newPasswordModule(t, "jane@example.com", "secret")
+
+	for i := 0; i <= config.LoginFreeAttempts; i++ {
+		err := m.ChangePassword(ctx, "jane@example.com", "guess", "new-secret", "10.0.0.1")
+		if errorType(err) != response.ErrForbiddenResource {
+			t.Fatalf("attempt %d got %v", i+1, err)
+		}
+	}
+
+	// The right password waits too, and so do logins to the account
+	err := m.ChangePassword(ctx, "jane@example.com", "secret", "new-secret", "10.0.0.2")
+	if errorType(err) != response.ErrTooManyRequests || RetryAfter(err) == "" {
+		t.Fatalf("blocked attempt got %v", err)
+	}
+	if _, err := m.Authenticate(ctx, "jane@example.com", "secret", "10.0.0.2"); errorType(err) != response.ErrTooManyRequests {
+		t.Errorf("login while blocked got %v", err)
+	}
+	if wait := m.LoginGuard.Wait(IPKey("10.0.0.1")); wait != 0 {
+		t.Errorf("client IP blocked for %s after %d failures", wait, config.LoginFreeAttempts+1)
+	}
+}
+
+func TestChangePasswordSuccessResetsFailures(t *testing.T) {
+	ctx := context.Background()
+	m := newPasswordModule(t, "jane@example.com", "secret")
+
+	for i := 0; i < config.LoginFreeAttempts; i++ {
+		_ = m.ChangePassword(ctx, "jane@example.com", "guess", "new-secret", "10.0.0.1")
+	}
+	if err := m.ChangePassword(ctx, "jane@example.com", "secret", "new-secret", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A single wrong password after the reset is free again
+	err := m.ChangePassword(ctx, "jane@example.com", "guess", "other", "10.0.0.1")
+	if errorType(err) != response.ErrForbiddenResource {
+		t.Fatalf("got %v", err)
+	}
+	if wait := m.LoginGuard.Wait(AccountKey("jane@example.com")); wait != 0 {
+		t.Errorf("account blocked for %s", wait)
+	}
+	if _, err := m.Authenticate(ctx, "jane@example.com", "new-secret", "10.0.0.1"); err != nil {
+		t.Errorf("new password does not log in: %v", err)
+	}
+}
+
+func TestDeleteAccountRemovesPredictionJobs(t *testing.T) {
+	ctx := context.Background()
+	m := newPasswordModule(t, "jane@example.com", "secret")
+	s, bucket := newMemStorage()
+	m.Storage = s
+
+	set := func(collection string, id string, data map[string]interface{}) {
+		t.Helper()
+		if _, err := m.Firestore.Collection(collection).Doc(id).Set(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A finished job also has a prediction, pending and failed ones only have the job
+	for _, id := range []string{"done", "pending", "failed", "other"} {
+		bucket.put(imageObjects(id)...)
+	}
+	set(predictionsCollection, "done", map[string]interface{}{"id": "done", "user_id": "jane@example.com"})
+	set(jobsCollection, "done", map[string]interface{}{"id": "done", "user_id": "jane@example.com", "status": model.JobStatusCompleted})
+	set(jobsCollection, "pending", map[string]interface{}{"id": "pending", "user_id": "jane@example.com", "status": model.JobStatusPending})
+	set(jobsCollection, "failed", map[string]interface{}{"id": "failed", "user_id": "jane@example.com", "status": model.JobStatusFailed})
+	set(jobsCollection, "other", map[string]interface{}{"id": "other", "user_id": "john@example.com", "status": model.JobStatusPending})
+
+	if err := m.DeleteAccount(ctx, "jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := m.Firestore.Collection(jobsCollection).Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Ref.ID != "other" {
+		t.Errorf("jobs left: %d", len(docs))
+	}
+	want := imageObjects("other")
+	sort.Strings(want)
+	if got := strings.Join(bucket.names(), ","); got != strings.Join(want, ",") {
+		t.Errorf("objects left are %s, want %v", got, want)
+	}
+	if _, err := m.GetUser(ctx, "jane@example.com"); err == nil {
+		t.Error("user was not deleted")
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/fatih/structs"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/model"
+	"github.com/hansels/sense_backend/utils"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+func (a *API) GetMe(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	user, err := a.Module.GetProfile(ctx, r.Header.Get("UserID"))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(structs.Map(user))
+}
+
+// UpdateMe takes either a JSON body with the name, or a multipart form with an optional name field and an
+// optional photo file.
+func (a *API) UpdateMe(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	var profile model.ProfileData
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		err := r.ParseMultipartForm(maxBatchMemory)
+		if err != nil {
+			return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+		}
+
+		if names, ok := r.MultipartForm.Value["name"]; ok && len(names) > 0 {
+			profile.Name = &names[0]
+		}
+
+		file, _, err := r.FormFile("photo")
+		if err == nil {
+			defer file.Close()
+			profile.Photo, err = ioutil.ReadAll(io.LimitReader(file, config.MaxUploadImageSize+1))
+			if err != nil {
+				return response.NewJSONResponse().SetError(response.ErrInternalServerError).SetMessage("Internal Server Error")
+			}
+		}
+	} else {
+		err := json.NewDecoder(r.Body).Decode(&profile)
+		if err != nil {
+			log.WithContext(ctx).Errorf("ProfileData Json Decode Error : %+v", err)
+			return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+		}
+	}
+
+	user, err := a.Module.UpdateProfile(ctx, r.Header.Get("UserID"), profile)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(structs.Map(user))
+}
+
+func (a *API) ChangePassword(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	var req model.ChangePasswordData
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.WithContext(ctx).Errorf("ChangePasswordData Json Decode Error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+	}
+
+	err = a.Module.ChangePassword(ctx, r.Header.Get("UserID"), req.CurrentPassword, req.NewPassword, utils.ClientIP(r))
+	if err != nil {
+		return throttledResponse(w, err)
+	}
+
+	return response.NewJSONResponse().SetData("OK")
+}
+
+func (a *API) DeleteMe(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	err := a.Module.DeleteAccount(ctx, r.Header.Get("UserID"))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData("OK")
+}
@@ -2,19 +2,72 @@ package api
 
 import (
 	myRouter "github.com/hansels/sense_backend/common/router"
+	"github.com/hansels/sense_backend/config"
 	"github.com/hansels/sense_backend/src/sense"
 	"github.com/julienschmidt/httprouter"
+	"net/http"
 )
 
 func (a *API) Register(router *httprouter.Router) {
-	router.GET("/ping", myRouter.HandleNow("/ping", a.Ping))
+	r := myRouter.New(router, myRouter.Logging)
+	r.Timeouts = config.RouteTimeouts
 
-	router.POST("/check-user", myRouter.HandleNow("/check-user", a.CheckUser))
-	router.POST("/login", myRouter.HandleNow("/login", a.Login))
-	router.POST("/register", myRouter.HandleNow("/register", a.RegisterUser))
-	router.POST("/predict", myRouter.HandleNow("/predict", a.Predict))
+	// Released app builds call the unversioned paths, which stay on v1. New endpoint versions go in their own
+	// registerVx next to registerV1.
+	a.registerV1(r)
+	a.registerV1(r.Version("v1"))
 
-	router.POST("/internal/resort", myRouter.HandleNow("/internal/resort", a.Module.Authorize(a.InsertResort)))
+	// Operational endpoints are not part of the API, so they are not versioned
+	router.Handler(http.MethodGet, "/metrics", a.Metrics())
+	r.GET("/healthz", a.Healthz)
+	r.GET("/readyz", a.Readyz)
+}
+
+func (a *API) registerV1(r *myRouter.Router) {
+	r.GET("/ping", a.Ping)
+
+	r.POST("/check-user", a.CheckUser)
+	r.POST("/login", a.Login)
+	r.POST("/login/2fa", a.LoginTwoFactor)
+	r.POST("/login/firebase", a.LoginFirebase)
+	r.POST("/register", a.RegisterUser)
+	r.POST("/predict", a.Predict, a.Module.OptionalAuthorize, a.Module.RateLimit)
+	r.POST("/predict/batch", a.PredictBatch, a.Module.OptionalAuthorize, a.Module.RateLimit)
+	r.POST("/predict/jobs", a.CreatePredictionJob, a.Module.OptionalAuthorize, a.Module.RateLimit)
+	r.GET("/predict/jobs/:id", a.GetPredictionJob, a.Module.OptionalAuthorize)
+
+	user := r.Group("", a.Module.Authorize)
+	user.POST("/predictions/:id/feedback", a.SubmitFeedback)
+
+	images := user.Group("/images")
+	images.GET("/:id", a.GetImage)
+	images.POST("/:id/token", a.RotateImageToken)
+	images.DELETE("/:id/token", a.RevokeImageToken)
+
+	me := user.Group("/me")
+	me.GET("", a.GetMe)
+	me.PATCH("", a.UpdateMe)
+	me.DELETE("", a.DeleteMe)
+	me.POST("/password", a.ChangePassword)
+	me.POST("/2fa", a.EnrollTwoFactor)
+	me.POST("/2fa/confirm", a.ConfirmTwoFactor)
+	me.DELETE("/2fa", a.DisableTwoFactor)
+	me.GET("/export", a.ExportMe)
+	me.GET("/export/:id", a.GetMyExport)
+	me.DELETE("/images", a.DeleteMyImages)
+
+	// API keys reach the internal routes their scopes cover, the admin ones need an admin user
+	internal := r.Group("/internal", a.Module.Authorize, a.Module.RateLimit)
+	internal.POST("/resort", a.InsertResort)
+
+	admin := internal.Group("", a.Module.RequireAdmin)
+	admin.GET("/feedback/export", a.ExportFeedback)
+	admin.GET("/retention/report", a.RetentionReport)
+	admin.GET("/api-keys", a.ListAPIKeys)
+	admin.POST("/api-keys", a.CreateAPIKey)
+	admin.DELETE("/api-keys/:id", a.RevokeAPIKey)
+	admin.POST("/users/:email/unlock", a.UnlockLogin)
+	admin.PUT("/users/:email/retention", a.SetUserRetention)
 }
 
 type API struct {
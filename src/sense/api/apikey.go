@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	myRouter "github.com/hansels/sense_backend/common/router"
+	"github.com/hansels/sense_backend/src/model"
+	"net/http"
+)
+
+// CreateAPIKey returns the new key in plain text, the only time it is shown.
+func (a *API) CreateAPIKey(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	var req model.CreateAPIKeyData
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.WithContext(ctx).Errorf("CreateAPIKeyData Json Decode Error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+	}
+
+	apiKey, err := a.Module.CreateAPIKey(ctx, r.Header.Get("UserID"), req)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	a.audit(ctx, r, "api_key_created", map[string]string{"api_key_id": apiKey.ID})
+	return response.NewJSONResponse().SetData(apiKey)
+}
+
+func (a *API) ListAPIKeys(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	keys, err := a.Module.ListAPIKeys(ctx)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(keys)
+}
+
+func (a *API) RevokeAPIKey(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	id := myRouter.Param(r, "id")
+	err := a.Module.RevokeAPIKey(ctx, id)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	a.audit(ctx, r, "api_key_revoked", map[string]string{"api_key_id": id})
+	return response.NewJSONResponse().SetData("OK")
+}
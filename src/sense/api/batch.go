@@ -0,0 +1,129 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/sense"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const maxBatchMemory = 32 << 20
+
+// maxFormOverhead leaves room in an upload for the multipart boundaries, part headers and the small form fields.
+const maxFormOverhead = 1 << 20
+
+// limitUpload caps the request body at images uploads of the maximum image size, so an oversized request fails
+// while its form is parsed instead of being spooled to disk first.
+func limitUpload(w http.ResponseWriter, r *http.Request, images int) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(images)*config.MaxUploadImageSize+maxFormOverhead)
+}
+
+// uploadTooLarge reports whether err is from reading past the limit of limitUpload. The error of
+// http.MaxBytesReader has no type of its own to check for.
+func uploadTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "request body too large")
+}
+
+func uploadTooLargeResponse(images int) *response.JSONResponse {
+	max := int64(images)*config.MaxUploadImageSize + maxFormOverhead
+	return errorResponse(sense.ValidationError("data", response.DetailTooLarge, fmt.Sprintf("Upload exceeds %d bytes!", max)))
+}
+
+type batchFile struct {
+	Name string
+	Data []byte
+	Err  error
+}
+
+// readBatchFiles reads every uploaded image, expanding zip archives into their entries. Reading stops
+// as soon as the batch limit is exceeded so oversized archives are never fully extracted.
+func readBatchFiles(headers []*multipart.FileHeader) []batchFile {
+	var files []batchFile
+	for _, header := range headers {
+		if len(files) > config.MaxBatchPredictImages {
+			break
+		}
+
+		data, err := readFileHeader(header)
+		if err != nil {
+			files = append(files, batchFile{Name: header.Filename, Err: err})
+			continue
+		}
+
+		if !isZip(header, data) {
+			files = append(files, batchFile{Name: header.Filename, Data: data})
+			continue
+		}
+
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			files = append(files, batchFile{Name: header.Filename, Err: fmt.Errorf("Invalid zip archive")})
+			continue
+		}
+
+		for _, entry := range archive.File {
+			if len(files) > config.MaxBatchPredictImages {
+				break
+			}
+			if entry.FileInfo().IsDir() {
+				continue
+			}
+
+			name := header.Filename + "/" + entry.Name
+			data, err := readZipEntry(entry)
+			if err != nil {
+				files = append(files, batchFile{Name: name, Err: err})
+				continue
+			}
+			files = append(files, batchFile{Name: name, Data: data})
+		}
+	}
+
+	return files
+}
+
+func readFileHeader(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readLimited(file)
+}
+
+func readZipEntry(entry *zip.File) ([]byte, error) {
+	file, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readLimited(file)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, config.MaxUploadImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > config.MaxUploadImageSize {
+		return nil, fmt.Errorf("Image exceeds %d bytes", config.MaxUploadImageSize)
+	}
+	return data, nil
+}
+
+func isZip(header *multipart.FileHeader, data []byte) bool {
+	if strings.EqualFold(path.Ext(header.Filename), ".zip") {
+		return true
+	}
+	return http.DetectContentType(data) == "application/zip"
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bytes"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func multipartRequest(t *testing.T, path string, files int, size int) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for i := 0; i < files; i++ {
+		part, err := writer.CreateFormFile("data", "image.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(make([]byte, size))
+	}
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, path, &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestUploadsOverTheLimitAreRejected(t *testing.T) {
+	a := &API{}
+	tests := []struct {
+		name    string
+		handler func(http.ResponseWriter, *http.Request) *response.JSONResponse
+		files   int
+	}{
+		{name: "predict", handler: a.Predict, files: 1},
+		{name: "batch", handler: a.PredictBatch, files: config.MaxBatchPredictImages},
+		{name: "job", handler: a.CreatePredictionJob, files: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One more image than allowed, each at the maximum size, is past any form overhead
+			r := multipartRequest(t, "/", tt.files+1, config.MaxUploadImageSize)
+			res := tt.handler(httptest.NewRecorder(), r)
+			if res.Error != response.ErrBadRequest || len(res.Details) != 1 || res.Details[0].Code != response.DetailTooLarge {
+				t.Errorf("got %v %+v", res.Error, res.Details)
+			}
+		})
+	}
+}
+
+func TestLimitUploadAllowsFormOverhead(t *testing.T) {
+	r := multipartRequest(t, "/", config.MaxBatchPredictImages, config.MaxUploadImageSize)
+	limitUpload(httptest.NewRecorder(), r, config.MaxBatchPredictImages)
+	if err := r.ParseMultipartForm(maxBatchMemory); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(r.MultipartForm.File["data"]); n != config.MaxBatchPredictImages {
+		t.Errorf("parsed %d files", n)
+	}
+	r.MultipartForm.RemoveAll()
+}
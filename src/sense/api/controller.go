@@ -1,18 +1,19 @@
 package api
 
 import (
-	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/fatih/structs"
-	"github.com/google/uuid"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/errors"
 	"github.com/hansels/sense_backend/common/log"
 	"github.com/hansels/sense_backend/common/response"
+	myRouter "github.com/hansels/sense_backend/common/router"
 	"github.com/hansels/sense_backend/config"
-	"github.com/hansels/sense_backend/src/firebase"
-	"github.com/hansels/sense_backend/src/ml"
 	"github.com/hansels/sense_backend/src/model"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/hansels/sense_backend/src/sense"
+	"github.com/hansels/sense_backend/utils"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -24,46 +25,103 @@ type MyClaims struct {
 }
 
 func (a *API) CheckUser(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	var req model.CheckUserData
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		log.Errorln("CheckUserData Json Decode Error : %+v", err)
-		return response.NewJSONResponse().SetData(false)
+		log.WithContext(ctx).Errorf("CheckUserData Json Decode Error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
 	}
 
-	_, err = a.Module.Firestore.Collection("users").Doc(req.Email).Get(ctx)
+	err = a.Module.CheckUser(ctx, req.Email, utils.ClientIP(r))
 	if err != nil {
-		return response.NewJSONResponse().SetData(false)
+		return throttledResponse(w, err)
 	}
 
+	// Always true, so the answer cannot be used to enumerate accounts. The app goes on to the password step, a
+	// wrong password and an unknown email fail the same way there.
 	return response.NewJSONResponse().SetData(true)
 }
 
 func (a *API) Login(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	var req model.LoginData
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		log.Errorln("LoginData Json Decode Error : %+v", err)
+		log.WithContext(ctx).Errorf("LoginData Json Decode Error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+	}
+
+	user, err := a.Module.Authenticate(ctx, req.Email, req.Password, utils.ClientIP(r))
+	if err != nil {
+		return throttledResponse(w, err)
+	}
+
+	enabled, err := a.Module.TwoFactorEnabled(ctx, user.Email)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if enabled {
+		return a.challengeResponse(user)
+	}
+
+	return a.loginResponse(user)
+}
+
+// UnlockLogin lets an admin lift a lockout of an account, and of a client IP when ?ip= is given.
+func (a *API) UnlockLogin(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	a.Module.UnlockLogin(myRouter.Param(r, "email"), myRouter.Query(r, "ip", ""))
+	return response.NewJSONResponse().SetData("OK")
+}
+
+// throttledResponse is errorResponse plus the Retry-After header for throttled login attempts.
+func throttledResponse(w http.ResponseWriter, err error) *response.JSONResponse {
+	retryAfter := sense.RetryAfter(err)
+	if retryAfter == "" {
+		return errorResponse(err)
+	}
+	w.Header().Set("Retry-After", retryAfter)
+	return errorResponse(err).SetLog("retry_after", retryAfter)
+}
+
+// LoginFirebase signs in with a Firebase Auth ID token and issues the normal Sense token.
+func (a *API) LoginFirebase(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	var req model.FirebaseLoginData
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.WithContext(ctx).Errorf("FirebaseLoginData Json Decode Error : %+v", err)
 		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
 	}
 
-	user, err := a.getUser(ctx, req.Email)
+	user, err := a.Module.SignInWithFirebase(ctx, req.IDToken)
 	if err != nil {
-		return response.NewJSONResponse().SetError(response.ErrNoValidUserFound).SetMessage("Login Unsuccessful!")
+		if errors.Is(err, response.ErrNoValidUserFound) {
+			return response.NewJSONResponse().SetError(err).SetMessage("Login Unsuccessful!")
+		}
+		return errorResponse(err)
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(config.PasswordSalt+req.Password))
+	// The ID token replaces the password, not the second factor
+	enabled, err := a.Module.TwoFactorEnabled(ctx, user.Email)
 	if err != nil {
-		return response.NewJSONResponse().SetError(response.ErrNoValidUserFound).SetMessage("Login Unsuccessful!")
+		return errorResponse(err)
+	}
+	if enabled {
+		return a.challengeResponse(user)
 	}
 
+	return a.loginResponse(user)
+}
+
+func (a *API) loginResponse(user *model.User) *response.JSONResponse {
 	claims := MyClaims{
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(time.Duration(720) * time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
 			Issuer:    "Sense",
 		},
 		Email: user.Email,
@@ -72,7 +130,7 @@ func (a *API) Login(w http.ResponseWriter, r *http.Request) *response.JSONRespon
 
 	signedToken, err := token.SignedString(config.SignatureKey)
 	if err != nil {
-		log.Errorln("JWT Token Signing error : %+v", err)
+		log.Errorf("JWT Token Signing error : %+v", err)
 		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
 	}
 
@@ -82,12 +140,12 @@ func (a *API) Login(w http.ResponseWriter, r *http.Request) *response.JSONRespon
 }
 
 func (a *API) RegisterUser(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	var user model.User
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		log.Errorln("RegisterData Json Decode Error : %+v", err)
+		log.WithContext(ctx).Errorf("RegisterData Json Decode Error : %+v", err)
 		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
 	}
 
@@ -98,17 +156,22 @@ func (a *API) RegisterUser(w http.ResponseWriter, r *http.Request) *response.JSO
 		return response.NewJSONResponse().SetError(response.ErrAlreadyRegistered).SetMessage("User Already Registered!")
 	}
 
-	password, err := bcrypt.GenerateFromPassword([]byte(config.PasswordSalt+user.Password), bcrypt.DefaultCost)
+	password, err := sense.HashPassword(user.Password)
 	if err != nil {
 		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
 	}
 
-	user.Password = string(password)
-	user.Type = "Member"
+	user.Password = password
+	user.Type = model.UserTypeMember
+	user.RetentionDays = 0
+	user.Photo = ""
+	user.PhotoID = ""
+	user.FirebaseUID = ""
+	user.TokensValidAfter = time.Now().Unix()
 
 	_, err = doc.Set(ctx, structs.Map(user))
 	if err != nil {
-		log.Errorln("Write to Firestore error : %+v", err)
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
 		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
 	}
 
@@ -116,11 +179,13 @@ func (a *API) RegisterUser(w http.ResponseWriter, r *http.Request) *response.JSO
 }
 
 func (a *API) Predict(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
-	ctx := context.Background()
+	ctx := r.Context()
 
-	// ML Prediction
-	mlModel := a.Module.Model
+	limitUpload(w, r, 1)
 	file, _, err := r.FormFile("data")
+	if uploadTooLarge(err) {
+		return uploadTooLargeResponse(1)
+	}
 	if err != nil {
 		return response.NewJSONResponse().SetError(response.ErrInternalServerError).SetMessage("Internal Server Error")
 	}
@@ -131,46 +196,77 @@ func (a *API) Predict(w http.ResponseWriter, r *http.Request) *response.JSONResp
 		return response.NewJSONResponse().SetError(response.ErrInternalServerError).SetMessage("Internal Server Error")
 	}
 
-	outcome := mlModel.Predict(fileBytes)
-	result, err := a.generateResultFromML(outcome)
+	result, err := a.Module.PredictImage(ctx, r.Header.Get("UserID"), fileBytes, r.FormValue("explain") == "true")
 	if err != nil {
-		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+		return errorResponse(err)
 	}
 
-	// Upload Image to Storage Firebase
-	id, err := uuid.NewUUID()
+	return response.NewJSONResponse().SetData(structs.Map(result))
+}
+
+func (a *API) PredictBatch(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	limitUpload(w, r, config.MaxBatchPredictImages)
+	err := r.ParseMultipartForm(maxBatchMemory)
+	if uploadTooLarge(err) {
+		return uploadTooLargeResponse(config.MaxBatchPredictImages)
+	}
 	if err != nil {
-		return response.NewJSONResponse().SetError(response.ErrInternalServerError).SetMessage("Internal Server Error")
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
 	}
 
-	uploadData := firebase.UploadImageData{
-		Ctx:      ctx,
-		FileName: id.String(),
-		File:     fileBytes,
+	files := readBatchFiles(r.MultipartForm.File["data"])
+	if len(files) == 0 {
+		return errorResponse(sense.ValidationError("data", response.DetailRequired, "No Images Uploaded!"))
 	}
-	url, err := a.Module.Storage.UploadImage(uploadData)
-	if err != nil {
-		return response.NewJSONResponse().SetError(response.ErrInternalServerError).SetMessage("Internal Server Error")
+
+	if len(files) > config.MaxBatchPredictImages {
+		return errorResponse(sense.ValidationError("data", response.DetailTooLarge, fmt.Sprintf("Maximum %d Images Per Batch!", config.MaxBatchPredictImages)))
 	}
 
-	// URL for Download Image, currently log for health checking
-	log.Infoln(url)
-	result.Image = url
-	return response.NewJSONResponse().SetData(structs.Map(result))
+	// One bad image only fails its own item, never the whole batch
+	userID := r.Header.Get("UserID")
+	explain := r.FormValue("explain") == "true"
+	items := make([]model.BatchPredictionItem, 0, len(files))
+	for _, file := range files {
+		// Nobody is waiting for the rest once the request timed out
+		if ctx.Err() != nil {
+			return errorResponse(custerr.ErrChain{Message: "Request Timeout", Cause: ctx.Err(), Type: response.ErrTimeoutError})
+		}
+
+		item := model.BatchPredictionItem{Name: file.Name}
+		if file.Err != nil {
+			item.Error = file.Err.Error()
+			item.ErrorCode = response.CodeBadRequest
+			items = append(items, item)
+			continue
+		}
+
+		item.Result, err = a.Module.PredictImage(ctx, userID, file.Data, explain)
+		if err != nil {
+			log.WithContext(ctx).Errorf("Batch Prediction Error on %s : %+v", file.Name, err)
+			item.Error = sense.ErrorMessage(err)
+			item.ErrorCode = response.ErrorCode(err)
+		}
+		items = append(items, item)
+	}
+
+	return response.NewJSONResponse().SetData(items)
 }
 
 func (a *API) Ping(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
-	log.Println("PING Called!")
+	log.WithContext(r.Context()).Println("PING Called!")
 	return response.NewJSONResponse().SetData("Ping!!!")
 }
 
 func (a *API) InsertResort(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	var resort model.Resort
 	err := json.NewDecoder(r.Body).Decode(&resort)
 	if err != nil {
-		log.Errorln("Resort Json Decode Error : %+v", err)
+		log.WithContext(ctx).Errorf("Resort Json Decode Error : %+v", err)
 		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
 	}
 
@@ -178,41 +274,17 @@ func (a *API) InsertResort(w http.ResponseWriter, r *http.Request) *response.JSO
 
 	_, err = doc.Set(ctx, structs.Map(resort))
 	if err != nil {
-		log.Errorln("Write to Firestore error : %+v", err)
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
 		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
 	}
 
 	return response.NewJSONResponse().SetData("OK")
 }
 
-func (a *API) generateResultFromML(outcome *ml.ObjectDetectionResponse) (*model.PredictionResult, error) {
-	if outcome.NumDetections == 0 {
-		return &model.PredictionResult{IsDetected: false}, nil
-	}
-
-	result := &model.PredictionResult{IsDetected: true}
-	result.Verdict = outcome.Detections[0].Label
-
-	return result, nil
-}
-
-func (a *API) getUser(ctx context.Context, id string) (*model.User, error) {
-	ds, err := a.Module.Firestore.Collection("users").Doc(id).Get(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	jsonString, err := json.Marshal(ds.Data())
-	if err != nil {
-		log.Errorln("User Marshal Error : %+v", err)
-		return nil, err
-	}
-
-	user := &model.User{}
-	err = json.Unmarshal(jsonString, &user)
-	if err != nil {
-		log.Errorln("User Unmarshal Error : %+v", err)
-		return nil, err
+func errorResponse(err error) *response.JSONResponse {
+	resp := response.NewJSONResponse().SetError(err)
+	if resp.StatusCode == http.StatusInternalServerError {
+		return resp.SetMessage("Internal Server Error")
 	}
-	return user, nil
+	return resp.SetMessage(sense.ErrorMessage(err))
 }
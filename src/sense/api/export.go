@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"github.com/hansels/sense_backend/common/response"
+	myRouter "github.com/hansels/sense_backend/common/router"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/model"
+	"github.com/hansels/sense_backend/src/sense"
+	"github.com/hansels/sense_backend/utils"
+	"net/http"
+	"time"
+)
+
+// ExportMe downloads everything stored about the user as JSON, or as a zip with format=zip. Large exports,
+// or any export with async=true, are built in the background and fetched through GetMyExport.
+func (a *API) ExportMe(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+	userID := r.Header.Get("UserID")
+
+	format := myRouter.Query(r, "format", model.ExportFormatJSON)
+	if format != model.ExportFormatJSON && format != model.ExportFormatZip {
+		return errorResponse(sense.ValidationError("format", response.DetailUnknown, "Unknown Export Format!"))
+	}
+
+	async := myRouter.QueryBool(r, "async")
+	if !async {
+		count, err := a.Module.CountUserPredictions(ctx, userID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		async = count > config.ExportSyncLimit
+	}
+
+	if async {
+		export, err := a.Module.StartDataExport(ctx, userID, format)
+		if err != nil {
+			return errorResponse(err)
+		}
+
+		a.audit(ctx, r, "data_export", map[string]string{"format": format, "mode": "async", "export_id": export.ID})
+		return response.NewJSONResponse().SetData(export)
+	}
+
+	export, err := a.Module.ExportUserData(ctx, userID)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	a.audit(ctx, r, "data_export", map[string]string{"format": format, "mode": "sync"})
+
+	filename := "sense-export-" + time.Now().Format("20060102150405") + "." + format
+	contentType := "application/json"
+	if format == model.ExportFormatZip {
+		contentType = "application/zip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+
+	_ = a.Module.WriteExport(ctx, w, export, format)
+	return nil
+}
+
+func (a *API) GetMyExport(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	export, err := a.Module.GetDataExport(ctx, r.Header.Get("UserID"), myRouter.Param(r, "id"))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	if export.URL != "" {
+		a.audit(ctx, r, "data_export_download", map[string]string{"export_id": export.ID})
+	}
+	return response.NewJSONResponse().SetData(export)
+}
+
+func (a *API) audit(ctx context.Context, r *http.Request, action string, details map[string]string) {
+	a.Module.Audit(ctx, model.AuditLog{
+		UserID:  r.Header.Get("UserID"),
+		Action:  action,
+		IP:      utils.ClientIP(r),
+		Details: details,
+	})
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	myRouter "github.com/hansels/sense_backend/common/router"
+	"github.com/hansels/sense_backend/src/model"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (a *API) SubmitFeedback(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	var req model.FeedbackData
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.WithContext(ctx).Errorf("FeedbackData Json Decode Error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+	}
+
+	feedback, err := a.Module.SubmitFeedback(ctx, r.Header.Get("UserID"), myRouter.Param(r, "id"), req.Label)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(feedback)
+}
+
+// ExportFeedback writes the labeled dataset manifest as CSV, or as JSON lines with format=jsonl.
+func (a *API) ExportFeedback(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	feedbacks, err := a.Module.ListFeedback(ctx)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	filename := "feedback-" + time.Now().Format("20060102150405")
+	if myRouter.Query(r, "format", "csv") == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+".jsonl\"")
+		encoder := json.NewEncoder(w)
+		for _, feedback := range feedbacks {
+			_ = encoder.Encode(feedback)
+		}
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+".csv\"")
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"prediction_id", "image", "label", "verdict", "correct", "created_at"})
+	for _, feedback := range feedbacks {
+		_ = writer.Write([]string{
+			feedback.PredictionID,
+			feedback.Image,
+			feedback.Label,
+			feedback.Verdict,
+			strconv.FormatBool(feedback.Correct),
+			feedback.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+	return nil
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/src/model"
+	"net/http"
+)
+
+// Healthz answers as long as the process serves requests, for liveness probes.
+func (a *API) Healthz(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	return response.NewJSONResponse().SetData(map[string]string{"status": model.HealthStatusOK})
+}
+
+// Readyz reports whether the model, Firestore and the bucket work, for readiness probes. It answers 503 while a
+// dependency fails or the server shuts down. Probes share the checks of the last few seconds, see Module.Readiness.
+func (a *API) Readyz(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	readiness := a.Module.Readiness(r.Context())
+
+	resp := response.NewJSONResponse().SetData(readiness)
+	if readiness.Status != model.ReadinessReady {
+		resp.SetStatus(http.StatusServiceUnavailable)
+	}
+	return resp
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"github.com/hansels/sense_backend/common/response"
+	myRouter "github.com/hansels/sense_backend/common/router"
+	"net/http"
+)
+
+// GetImage redirects to a short lived signed URL of a prediction image the user owns.
+func (a *API) GetImage(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	url, err := a.Module.ImageURL(ctx, r.Header.Get("UserID"), myRouter.Param(r, "id"), myRouter.Query(r, "variant", ""))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	http.Redirect(w, r, url, http.StatusFound)
+	return nil
+}
+
+func (a *API) RotateImageToken(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	prediction, err := a.Module.RotateImageToken(ctx, r.Header.Get("UserID"), myRouter.Param(r, "id"))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(prediction)
+}
+
+func (a *API) RevokeImageToken(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	err := a.Module.RevokeImageToken(ctx, r.Header.Get("UserID"), myRouter.Param(r, "id"))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData("OK")
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"github.com/hansels/sense_backend/common/response"
+	myRouter "github.com/hansels/sense_backend/common/router"
+	"io/ioutil"
+	"net/http"
+)
+
+func (a *API) CreatePredictionJob(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	// Checked before the webhook, reading it parses the whole form
+	limitUpload(w, r, 1)
+	if err := r.ParseMultipartForm(maxBatchMemory); uploadTooLarge(err) {
+		return uploadTooLargeResponse(1)
+	}
+
+	webhook := r.FormValue("webhook")
+	if webhook != "" {
+		if err := a.Module.ValidateWebhook(ctx, webhook); err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	file, _, err := r.FormFile("data")
+	if err != nil {
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+	}
+	defer file.Close()
+
+	fileBytes, err := ioutil.ReadAll(file)
+	if err != nil {
+		return response.NewJSONResponse().SetError(response.ErrInternalServerError).SetMessage("Internal Server Error")
+	}
+
+	job, err := a.Module.EnqueuePredictionJob(ctx, r.Header.Get("UserID"), fileBytes, r.FormValue("explain") == "true", webhook)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(job.Public())
+}
+
+func (a *API) GetPredictionJob(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	job, err := a.Module.GetPredictionJobFor(ctx, myRouter.Param(r, "id"), r.Header.Get("UserID"), r.Header.Get("X-Job-Token"))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(job.Public())
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"crypto/subtle"
+	"github.com/hansels/sense_backend/common/metrics"
+	"github.com/hansels/sense_backend/config"
+	"net/http"
+)
+
+// Metrics serves the Prometheus metrics, to scrapers sending config.MetricsToken when one is set. It is mounted
+// on the plain router, so scrapes are neither logged nor counted in the request metrics they report.
+func (a *API) Metrics() http.Handler {
+	handler := metrics.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.MetricsToken != "" {
+			token := []byte("Bearer " + config.MetricsToken)
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+				http.Error(w, "Invalid metrics token", http.StatusForbidden)
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	myRouter "github.com/hansels/sense_backend/common/router"
+	"github.com/hansels/sense_backend/src/model"
+	"net/http"
+)
+
+func (a *API) RetentionReport(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	report, err := a.Module.RetentionReport(ctx)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(report)
+}
+
+func (a *API) SetUserRetention(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	var req model.RetentionData
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.WithContext(ctx).Errorf("RetentionData Json Decode Error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+	}
+
+	err = a.Module.SetUserRetention(ctx, myRouter.Param(r, "email"), req.Days)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData("OK")
+}
+
+func (a *API) DeleteMyImages(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	deleted, err := a.Module.DeleteUserImages(ctx, r.Header.Get("UserID"))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(map[string]interface{}{"deleted": deleted})
+}
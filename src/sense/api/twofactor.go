@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/src/model"
+	"github.com/hansels/sense_backend/utils"
+	"net/http"
+)
+
+func (a *API) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	enrollment, err := a.Module.EnrollTwoFactor(ctx, r.Header.Get("UserID"))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return response.NewJSONResponse().SetData(enrollment)
+}
+
+// ConfirmTwoFactor enables two-factor authentication and returns the recovery codes, the only time they are shown.
+func (a *API) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	var req model.TwoFactorCodeData
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.WithContext(ctx).Errorf("TwoFactorCodeData Json Decode Error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+	}
+
+	codes, err := a.Module.ConfirmTwoFactor(ctx, r.Header.Get("UserID"), req.Code, utils.ClientIP(r))
+	if err != nil {
+		return throttledResponse(w, err)
+	}
+
+	a.audit(ctx, r, "two_factor_enabled", nil)
+	return response.NewJSONResponse().SetData(map[string]interface{}{"recovery_codes": codes})
+}
+
+func (a *API) DisableTwoFactor(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	var req model.DisableTwoFactorData
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.WithContext(ctx).Errorf("DisableTwoFactorData Json Decode Error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+	}
+
+	err = a.Module.DisableTwoFactor(ctx, r.Header.Get("UserID"), req.Password, req.Code, utils.ClientIP(r))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	a.audit(ctx, r, "two_factor_disabled", nil)
+	return response.NewJSONResponse().SetData("OK")
+}
+
+// LoginTwoFactor is the second step of Login for accounts with two-factor authentication.
+func (a *API) LoginTwoFactor(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+	ctx := r.Context()
+
+	var req model.TwoFactorLoginData
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.WithContext(ctx).Errorf("TwoFactorLoginData Json Decode Error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrBadRequest).SetMessage("Bad Request")
+	}
+
+	user, err := a.Module.VerifyTwoFactor(ctx, req.ChallengeToken, req.Code, utils.ClientIP(r))
+	if err != nil {
+		return throttledResponse(w, err)
+	}
+
+	return a.loginResponse(user)
+}
+
+// challengeResponse answers a correct password on an account with two-factor authentication. The challenge token
+// is exchanged for a session token at /login/2fa.
+func (a *API) challengeResponse(user *model.User) *response.JSONResponse {
+	challenge, err := a.Module.TwoFactorChallenge(user.Email)
+	if err != nil {
+		log.Errorf("JWT Token Signing error : %+v", err)
+		return response.NewJSONResponse().SetError(response.ErrInternalServerError).SetMessage("Internal Server Error")
+	}
+
+	return response.NewJSONResponse().SetData(map[string]interface{}{"two_factor_required": true, "challenge_token": challenge})
+}
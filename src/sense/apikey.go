@@ -0,0 +1,184 @@
+package sense
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/fatih/structs"
+	"github.com/google/uuid"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/model"
+	"github.com/hansels/sense_backend/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeysCollection = "api_keys"
+	apiKeyHeader      = "X-Authorization"
+	apiKeyPrefix      = "sense_"
+
+	// APIKeyUserPrefix marks the UserID of requests authorized by an API key instead of a user
+	APIKeyUserPrefix = "apikey:"
+)
+
+// CreateAPIKey issues a key for the given route scopes. The returned key is not stored and cannot be shown again.
+func (m *Module) CreateAPIKey(ctx context.Context, createdBy string, req model.CreateAPIKeyData) (*model.CreatedAPIKey, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, ValidationError("name", response.DetailRequired, "Name must not be empty")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, ValidationError("scopes", response.DetailRequired, "At least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !strings.HasPrefix(scope, "/") {
+			return nil, ValidationError("scopes", response.DetailInvalid, "Scopes must be route paths").SetField("scope", scope)
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		return nil, ValidationError("expires_in_days", response.DetailInvalid, "Expiry must not be negative")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, custerr.ErrChain{Message: "Generate key failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	id := strings.Replace(uuid.New().String(), "-", "", -1)
+	key := apiKeyPrefix + id + "." + hex.EncodeToString(secret)
+	apiKey := model.APIKey{
+		ID:        id,
+		Name:      name,
+		Hash:      hashAPIKey(key),
+		Scopes:    req.Scopes,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if req.ExpiresInDays > 0 {
+		apiKey.ExpiresAt = apiKey.CreatedAt.AddDate(0, 0, req.ExpiresInDays)
+	}
+
+	_, err := m.Firestore.Collection(apiKeysCollection).Doc(id).Set(ctx, structs.Map(apiKey))
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+		return nil, custerr.ErrChain{Message: "Save API key failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	apiKey.Hash = ""
+	return &model.CreatedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+// ListAPIKeys returns every API key without its hash, newest first.
+func (m *Module) ListAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	docs, err := m.Firestore.Collection(apiKeysCollection).OrderBy("created_at", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read API keys failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	keys := make([]model.APIKey, 0, len(docs))
+	for _, doc := range docs {
+		var apiKey model.APIKey
+		err = decodeDocument(doc.Data(), &apiKey)
+		if err != nil {
+			log.WithContext(ctx).Errorf("APIKey Unmarshal Error : %+v", err)
+			continue
+		}
+		apiKey.Hash = ""
+		keys = append(keys, apiKey)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey deletes a key, requests using it are rejected from then on.
+func (m *Module) RevokeAPIKey(ctx context.Context, id string) error {
+	_, err := m.getAPIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.Firestore.Collection(apiKeysCollection).Doc(id).Delete(ctx)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Delete from Firestore error : %+v", err)
+		return custerr.ErrChain{Message: "Revoke API key failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+// GetAuthorizationFromAPIKey checks a key and that its scopes cover the route. It returns the UserID requests
+// made with the key run as.
+func (m *Module) GetAuthorizationFromAPIKey(ctx context.Context, key string, route string) (string, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", errors.New("API key invalid")
+	}
+
+	id := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), ".", 2)[0]
+	apiKey, err := m.getAPIKey(ctx, id)
+	if err != nil {
+		return "", errors.New("API key invalid")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.Hash), []byte(hashAPIKey(key))) != 1 {
+		return "", errors.New("API key invalid")
+	}
+
+	now := time.Now()
+	if !apiKey.ExpiresAt.IsZero() && now.After(apiKey.ExpiresAt) {
+		return "", errors.New("API key expired")
+	}
+
+	if !inScope(apiKey.Scopes, route) {
+		return "", errors.New("API key not allowed on " + route)
+	}
+
+	// Only touch the record once in a while, not on every request
+	if now.Sub(apiKey.LastUsedAt) > config.APIKeyLastUsedInterval {
+		_, err = m.Firestore.Collection(apiKeysCollection).Doc(id).Update(ctx, []firestore.Update{{Path: "last_used_at", Value: now}})
+		if err != nil {
+			log.WithContext(ctx).Errorf("Update API key %s last used error : %+v", id, err)
+		}
+	}
+
+	return APIKeyUserPrefix + id, nil
+}
+
+func (m *Module) getAPIKey(ctx context.Context, id string) (*model.APIKey, error) {
+	ds, err := m.Firestore.Collection(apiKeysCollection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, custerr.ErrChain{Message: "API key not found", Cause: err, Type: response.ErrNotFound}
+	}
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read API key failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	apiKey := &model.APIKey{}
+	err = decodeDocument(ds.Data(), apiKey)
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read API key failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return apiKey, nil
+}
+
+func inScope(scopes []string, route string) bool {
+	for _, scope := range scopes {
+		if scope == route {
+			return true
+		}
+		if strings.HasSuffix(scope, "/*") && strings.HasPrefix(route, strings.TrimSuffix(scope, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPIKey(key string) string {
+	return utils.GenerateSHA256(config.PasswordSalt, key)
+}
@@ -0,0 +1,21 @@
+package sense
+
+import (
+	"context"
+	"github.com/fatih/structs"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/src/model"
+	"time"
+)
+
+const auditCollection = "audit_logs"
+
+// Audit records a sensitive action. Failing to write the audit log is logged but never fails the action.
+func (m *Module) Audit(ctx context.Context, entry model.AuditLog) {
+	entry.CreatedAt = time.Now()
+
+	_, _, err := m.Firestore.Collection(auditCollection).Add(ctx, structs.Map(entry))
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write Audit Log %s for %s error : %+v", entry.Action, entry.UserID, err)
+	}
+}
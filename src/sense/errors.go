@@ -0,0 +1,33 @@
+package sense
+
+import (
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/errors"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/src/firebase"
+)
+
+// ErrorMessage is the client facing message of an error returned by the module, errors that were not meant for
+// clients only say that something went wrong.
+func ErrorMessage(err error) string {
+	var chain custerr.ErrChain
+	if errors.As(err, &chain) {
+		return chain.Message
+	}
+	return "Internal Server Error"
+}
+
+// ValidationError rejects a request because of one of its fields.
+func ValidationError(field string, code string, message string) custerr.ErrChain {
+	return custerr.ErrChain{Message: message, Type: response.ErrBadRequest, Code: response.CodeValidationFailed}.
+		AddDetail(field, code, message)
+}
+
+// imageError turns an image rejected by firebase.NormalizeImage into a client error on the form field.
+func imageError(err error, field string) error {
+	chain := custerr.ErrChain{Message: err.Error(), Cause: err, Type: response.ErrBadRequest, Code: response.CodeInvalidImage}
+	if errors.Is(err, firebase.ErrImageTooLarge) {
+		return chain.SetCode(response.CodeImageTooLarge).AddDetail(field, response.DetailTooLarge, err.Error())
+	}
+	return chain.AddDetail(field, response.DetailInvalid, err.Error())
+}
@@ -0,0 +1,313 @@
+package sense
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/fatih/structs"
+	"github.com/google/uuid"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/firebase"
+	"github.com/hansels/sense_backend/src/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"time"
+)
+
+const exportsCollection = "data_exports"
+
+// ExportUserData assembles everything stored about a user. The password hash is never included.
+func (m *Module) ExportUserData(ctx context.Context, userID string) (*model.UserExport, error) {
+	profile, err := m.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &model.UserExport{
+		GeneratedAt: time.Now(),
+		Profile:     profile,
+		Predictions: []model.Prediction{},
+		Jobs:        []model.PredictionJob{},
+		Feedback:    []model.PredictionFeedback{},
+		Reviews:     []model.UserReview{},
+		AuditLogs:   []model.AuditLog{},
+		Images:      []model.ImageReference{},
+	}
+
+	predictions, err := m.Firestore.Collection(predictionsCollection).Where("user_id", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read predictions failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	for _, doc := range predictions {
+		var prediction model.Prediction
+		if err := decodeDocument(doc.Data(), &prediction); err != nil {
+			log.WithContext(ctx).Errorf("Prediction Unmarshal Error : %+v", err)
+			continue
+		}
+		export.Predictions = append(export.Predictions, prediction)
+		export.Images = append(export.Images, model.ImageReference{
+			ID:          prediction.ID,
+			Image:       prediction.Image,
+			Thumbnail:   prediction.Thumbnail,
+			Explanation: prediction.Explanation,
+		})
+	}
+
+	jobs, err := m.Firestore.Collection(jobsCollection).Where("user_id", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read prediction jobs failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	for _, doc := range jobs {
+		var job model.PredictionJob
+		if err := decodeDocument(doc.Data(), &job); err != nil {
+			log.WithContext(ctx).Errorf("Job Unmarshal Error : %+v", err)
+			continue
+		}
+		export.Jobs = append(export.Jobs, *job.Public())
+		// Completed jobs share the id and the images of their prediction
+		if job.Status != model.JobStatusCompleted {
+			export.Images = append(export.Images, model.ImageReference{ID: job.ID, Image: job.Image, Thumbnail: job.Thumbnail})
+		}
+	}
+
+	if profile.PhotoID != "" {
+		export.Images = append(export.Images, model.ImageReference{ID: profile.PhotoID, Image: profile.Photo})
+	}
+
+	feedback, err := m.Firestore.Collection(feedbackCollection).Where("user_id", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read feedback failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	for _, doc := range feedback {
+		var item model.PredictionFeedback
+		if err := decodeDocument(doc.Data(), &item); err != nil {
+			log.WithContext(ctx).Errorf("Feedback Unmarshal Error : %+v", err)
+			continue
+		}
+		export.Feedback = append(export.Feedback, item)
+	}
+
+	resorts, err := m.Firestore.Collection(resortsCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read reviews failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	for _, doc := range resorts {
+		var resort model.Resort
+		if err := decodeDocument(doc.Data(), &resort); err != nil {
+			log.WithContext(ctx).Errorf("Resort Unmarshal Error : %+v", err)
+			continue
+		}
+		for _, review := range resort.Reviews {
+			if review.User.Email == userID {
+				review.User.Password = ""
+				export.Reviews = append(export.Reviews, model.UserReview{Resort: resort.Name, Review: review})
+			}
+		}
+	}
+
+	auditLogs, err := m.Firestore.Collection(auditCollection).Where("user_id", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read audit logs failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	for _, doc := range auditLogs {
+		var entry model.AuditLog
+		if err := decodeDocument(doc.Data(), &entry); err != nil {
+			log.WithContext(ctx).Errorf("Audit Log Unmarshal Error : %+v", err)
+			continue
+		}
+		export.AuditLogs = append(export.AuditLogs, entry)
+	}
+
+	return export, nil
+}
+
+// CountUserPredictions is used to decide whether an export is small enough to build within the request.
+func (m *Module) CountUserPredictions(ctx context.Context, userID string) (int, error) {
+	docs, err := m.Firestore.Collection(predictionsCollection).Where("user_id", "==", userID).Select().Documents(ctx).GetAll()
+	if err != nil {
+		return 0, custerr.ErrChain{Message: "Read predictions failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return len(docs), nil
+}
+
+// WriteExport encodes an export as JSON, or as a zip holding the JSON and the stored images.
+func (m *Module) WriteExport(ctx context.Context, w io.Writer, export *model.UserExport, format string) error {
+	if format != model.ExportFormatZip {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(export)
+	}
+
+	archive := zip.NewWriter(w)
+	file, err := archive.Create("export.json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return err
+	}
+
+	for _, image := range export.Images {
+		data, err := m.Storage.ReadImage(ctx, image.ID)
+		if err != nil {
+			log.WithContext(ctx).Errorf("Export Read Image %s Error : %+v", image.ID, err)
+			continue
+		}
+
+		file, err := archive.Create("images/" + image.ID + ".jpg")
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// StartDataExport builds the export in the background and stores the archive privately in storage. New exports
+// are refused once the module is stopping.
+func (m *Module) StartDataExport(ctx context.Context, userID string, format string) (*model.DataExport, error) {
+	select {
+	case <-m.stop:
+		return nil, errShuttingDown
+	default:
+	}
+
+	now := time.Now()
+	export := &model.DataExport{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Format:    format,
+		Status:    model.JobStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := m.saveDataExport(ctx, export)
+	if err != nil {
+		return nil, err
+	}
+
+	if !m.goBackground(func() { m.runDataExport(export) }) {
+		// Stopped since the check above, the record must not stay pending forever
+		export.Status = model.JobStatusFailed
+		export.Error = ErrorMessage(errShuttingDown)
+		export.UpdatedAt = time.Now()
+		_ = m.saveDataExport(ctx, export)
+		return nil, errShuttingDown
+	}
+	return export, nil
+}
+
+// GetDataExport returns an export of the user, with a signed download URL once it is completed.
+func (m *Module) GetDataExport(ctx context.Context, userID string, id string) (*model.DataExport, error) {
+	ds, err := m.Firestore.Collection(exportsCollection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, custerr.ErrChain{Message: "Export not found", Cause: err, Type: response.ErrNotFound}
+	}
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read export failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	export := &model.DataExport{}
+	err = decodeDocument(ds.Data(), export)
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read export failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	if export.UserID != userID {
+		return nil, custerr.ErrChain{Message: "Export not found", Type: response.ErrNotFound}
+	}
+
+	if export.Status == model.JobStatusCompleted {
+		export.URL, err = m.Storage.SignedURL(exportObject(export), config.SignedURLExpiry)
+		if err != nil {
+			return nil, custerr.ErrChain{Message: "Sign export url failed", Cause: err, Type: response.ErrInternalServerError}
+		}
+	}
+	return export, nil
+}
+
+func (m *Module) runDataExport(export *model.DataExport) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ExportTimeout)
+	defer cancel()
+
+	export.Status = model.JobStatusRunning
+	export.UpdatedAt = time.Now()
+	_ = m.saveDataExport(ctx, export)
+
+	err := m.buildDataExport(ctx, export)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Data Export %s Error : %+v", export.ID, err)
+		export.Status = model.JobStatusFailed
+		export.Error = ErrorMessage(err)
+	} else {
+		export.Status = model.JobStatusCompleted
+	}
+	export.UpdatedAt = time.Now()
+	_ = m.saveDataExport(ctx, export)
+}
+
+func (m *Module) buildDataExport(ctx context.Context, export *model.DataExport) error {
+	data, err := m.ExportUserData(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = m.WriteExport(ctx, &buf, data, export.Format)
+	if err != nil {
+		return err
+	}
+
+	contentType := "application/json"
+	if export.Format == model.ExportFormatZip {
+		contentType = "application/zip"
+	}
+	return m.Storage.UploadExport(firebase.UploadImageData{Ctx: ctx, FileName: exportObject(export), File: buf.Bytes()}, contentType)
+}
+
+// deleteUserExports removes every export archive of the user together with its record.
+func (m *Module) deleteUserExports(ctx context.Context, userID string) error {
+	docs, err := m.Firestore.Collection(exportsCollection).Where("user_id", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		var export model.DataExport
+		if err := decodeDocument(doc.Data(), &export); err != nil {
+			log.WithContext(ctx).Errorf("Export Unmarshal Error : %+v", err)
+			continue
+		}
+		if err := m.Storage.DeleteObjects(ctx, exportObject(&export)); err != nil {
+			return err
+		}
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Module) saveDataExport(ctx context.Context, export *model.DataExport) error {
+	_, err := m.Firestore.Collection(exportsCollection).Doc(export.ID).Set(ctx, structs.Map(export))
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+		return custerr.ErrChain{Message: "Save export failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+func exportObject(export *model.DataExport) string {
+	return "exports/" + export.ID + "." + export.Format
+}
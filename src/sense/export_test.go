@@ -0,0 +1,78 @@
+package sense
+
+import (
+	"context"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/src/model"
+	"testing"
+	"time"
+)
+
+func TestExportUserDataIncludesJobsAndAuditLogs(t *testing.T) {
+	ctx := context.Background()
+	m := newPasswordModule(t, "jane@example.com", "secret")
+
+	for _, job := range []model.PredictionJob{
+		{ID: "done", UserID: "jane@example.com", Status: model.JobStatusCompleted, TokenHash: "hash", Image: "done.jpg"},
+		{ID: "pending", UserID: "jane@example.com", Status: model.JobStatusPending, TokenHash: "hash", Image: "pending.jpg"},
+		{ID: "other", UserID: "john@example.com", Status: model.JobStatusPending, Image: "other.jpg"},
+	} {
+		job := job
+		if err := m.saveJob(ctx, &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Audit(ctx, model.AuditLog{UserID: "jane@example.com", Action: "password_changed", IP: "10.0.0.1"})
+	m.Audit(ctx, model.AuditLog{UserID: "john@example.com", Action: "account_deleted"})
+
+	export, err := m.ExportUserData(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(export.Jobs) != 2 || export.Jobs[0].ID != "done" || export.Jobs[1].ID != "pending" {
+		t.Fatalf("exported jobs %+v", export.Jobs)
+	}
+	for _, job := range export.Jobs {
+		if job.TokenHash != "" {
+			t.Errorf("job %s exported with its token hash", job.ID)
+		}
+	}
+	// The image of the completed job belongs to its prediction, only the unfinished one is listed for the job
+	if len(export.Images) != 1 || export.Images[0].ID != "pending" || export.Images[0].Image != "pending.jpg" {
+		t.Errorf("exported images %+v", export.Images)
+	}
+
+	if len(export.AuditLogs) != 1 || export.AuditLogs[0].Action != "password_changed" || export.AuditLogs[0].IP != "10.0.0.1" {
+		t.Errorf("exported audit logs %+v", export.AuditLogs)
+	}
+	if export.Profile.Password != "" {
+		t.Error("password hash exported")
+	}
+}
+
+func TestStartDataExportAfterStop(t *testing.T) {
+	ctx := context.Background()
+	m := newPasswordModule(t, "jane@example.com", "secret")
+	m.stop = make(chan struct{})
+
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := m.Stop(stopCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.StartDataExport(ctx, "jane@example.com", model.ExportFormatJSON); errorType(err) != response.ErrServiceUnavailable {
+		t.Fatalf("got %v, want the service to be unavailable", err)
+	}
+	docs, err := m.Firestore.Collection(exportsCollection).Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 0 {
+		t.Errorf("%d exports recorded", len(docs))
+	}
+	if m.goBackground(func() { t.Error("ran after Stop") }) {
+		t.Error("background work accepted after Stop")
+	}
+}
@@ -0,0 +1,81 @@
+package sense
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"github.com/fatih/structs"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/src/model"
+	"strings"
+	"time"
+)
+
+const feedbackCollection = "prediction_feedback"
+
+// SubmitFeedback stores the ground truth label a user gives for a prediction. A prediction made by a
+// signed in user only accepts feedback from that user, later feedback replaces earlier feedback.
+func (m *Module) SubmitFeedback(ctx context.Context, userID string, predictionID string, label string) (*model.PredictionFeedback, error) {
+	label = strings.TrimSpace(label)
+	if !m.isKnownLabel(label) {
+		return nil, ValidationError("label", response.DetailUnknown, "Unknown label").SetField("label", label)
+	}
+
+	prediction, err := m.GetPrediction(ctx, predictionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if prediction.UserID != "" && prediction.UserID != userID {
+		return nil, custerr.ErrChain{Message: "Prediction belongs to another user", Type: response.ErrForbiddenResource}
+	}
+
+	feedback := &model.PredictionFeedback{
+		PredictionID: prediction.ID,
+		UserID:       userID,
+		Label:        label,
+		Verdict:      prediction.Verdict,
+		Correct:      label == strings.TrimSpace(prediction.Verdict),
+		Image:        prediction.Image,
+		CreatedAt:    time.Now(),
+	}
+
+	_, err = m.Firestore.Collection(feedbackCollection).Doc(prediction.ID).Set(ctx, structs.Map(feedback))
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+		return nil, custerr.ErrChain{Message: "Save feedback failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	return feedback, nil
+}
+
+// ListFeedback returns every labeled prediction, oldest first, for building retraining datasets.
+func (m *Module) ListFeedback(ctx context.Context) ([]model.PredictionFeedback, error) {
+	docs, err := m.Firestore.Collection(feedbackCollection).OrderBy("created_at", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read feedback failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	feedbacks := make([]model.PredictionFeedback, 0, len(docs))
+	for _, doc := range docs {
+		var feedback model.PredictionFeedback
+		err = decodeDocument(doc.Data(), &feedback)
+		if err != nil {
+			log.WithContext(ctx).Errorf("Feedback Unmarshal Error : %+v", err)
+			continue
+		}
+		feedbacks = append(feedbacks, feedback)
+	}
+
+	return feedbacks, nil
+}
+
+func (m *Module) isKnownLabel(label string) bool {
+	for _, known := range m.Model.Labels() {
+		if known == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package sense
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"firebase.google.com/go/auth"
+	"github.com/fatih/structs"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/src/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// TokenVerifier verifies Firebase Auth ID tokens, *auth.Client implements it.
+type TokenVerifier interface {
+	VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+}
+
+// SignInWithFirebase verifies a Firebase ID token (Google/Apple sign-in) and returns the user with its email,
+// linking an existing account or creating a new member.
+func (m *Module) SignInWithFirebase(ctx context.Context, idToken string) (*model.User, error) {
+	if m.Verifier == nil {
+		return nil, custerr.ErrChain{Message: "Firebase sign-in is not configured", Type: response.ErrServiceUnavailable}
+	}
+
+	token, err := m.Verifier.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Invalid ID token", Cause: err, Type: response.ErrNoValidUserFound}
+	}
+
+	email, _ := token.Claims["email"].(string)
+	verified, _ := token.Claims["email_verified"].(bool)
+	if email == "" || !verified {
+		return nil, custerr.ErrChain{Message: "ID token has no verified email", Type: response.ErrNoValidUserFound}
+	}
+
+	doc := m.Firestore.Collection(usersCollection).Doc(email)
+	user, err := m.GetUser(ctx, email)
+	if status.Code(err) == codes.NotFound {
+		name, _ := token.Claims["name"].(string)
+		picture, _ := token.Claims["picture"].(string)
+		user = &model.User{
+			Name:             name,
+			Email:            email,
+			Type:             model.UserTypeMember,
+			Photo:            picture,
+			FirebaseUID:      token.UID,
+			TokensValidAfter: time.Now().Unix(),
+		}
+
+		_, err = doc.Set(ctx, structs.Map(user))
+		if err != nil {
+			log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+			return nil, custerr.ErrChain{Message: "Create user failed", Cause: err, Type: response.ErrInternalServerError}
+		}
+		return user, nil
+	}
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read user failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	switch user.FirebaseUID {
+	case token.UID:
+	case "":
+		user.FirebaseUID = token.UID
+		_, err = doc.Set(ctx, map[string]interface{}{"firebase_uid": token.UID}, firestore.MergeAll)
+		if err != nil {
+			log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+			return nil, custerr.ErrChain{Message: "Link user failed", Cause: err, Type: response.ErrInternalServerError}
+		}
+	default:
+		return nil, custerr.ErrChain{Message: "Email is linked to another account", Type: response.ErrNoValidUserFound}
+	}
+
+	return user, nil
+}
@@ -0,0 +1,153 @@
+package sense
+
+import (
+	"context"
+	"errors"
+	"firebase.google.com/go/auth"
+	"github.com/fatih/structs"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/src/model"
+	"testing"
+)
+
+// fakeVerifier accepts one ID token.
+type fakeVerifier struct {
+	idToken string
+	token   *auth.Token
+}
+
+func (v fakeVerifier) VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error) {
+	if idToken != v.idToken {
+		return nil, errors.New("invalid ID token")
+	}
+	return v.token, nil
+}
+
+func firebaseToken(uid string, email string, verified bool) *auth.Token {
+	return &auth.Token{UID: uid, Claims: map[string]interface{}{
+		"email":          email,
+		"email_verified": verified,
+		"name":           "Jane Doe",
+		"picture":        "https://example.com/jane.png",
+	}}
+}
+
+func newFirebaseModule(t *testing.T, token *auth.Token, users ...model.User) *Module {
+	t.Helper()
+
+	m := &Module{Firestore: newTestFirestore(t), Verifier: fakeVerifier{idToken: "id-token", token: token}}
+	for _, user := range users {
+		_, err := m.Firestore.Collection(usersCollection).Doc(user.Email).Set(context.Background(), structs.Map(user))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func errorType(err error) error {
+	var chain custerr.ErrChain
+	if errors.As(err, &chain) {
+		return chain.Type
+	}
+	return nil
+}
+
+func TestSignInWithFirebaseCreatesUser(t *testing.T) {
+	ctx := context.Background()
+	m := newFirebaseModule(t, firebaseToken("uid-1", "jane@example.com", true))
+
+	user, err := m.SignInWithFirebase(ctx, "id-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "jane@example.com" || user.FirebaseUID != "uid-1" || user.Type != model.UserTypeMember {
+		t.Errorf("signed in as %+v", user)
+	}
+
+	stored, err := m.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("user was not created: %v", err)
+	}
+	if stored.Name != "Jane Doe" || stored.Photo != "https://example.com/jane.png" || stored.FirebaseUID != "uid-1" {
+		t.Errorf("created %+v", stored)
+	}
+	if stored.Password != "" {
+		t.Error("created user has a password")
+	}
+	if stored.TokensValidAfter == 0 {
+		t.Error("created user accepts tokens issued before it existed")
+	}
+}
+
+func TestSignInWithFirebaseLinksExistingUser(t *testing.T) {
+	ctx := context.Background()
+	existing := model.User{Name: "Jane", Email: "jane@example.com", Password: "hash", Type: model.UserTypeAdmin}
+	m := newFirebaseModule(t, firebaseToken("uid-1", "jane@example.com", true), existing)
+
+	user, err := m.SignInWithFirebase(ctx, "id-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FirebaseUID != "uid-1" || user.Type != model.UserTypeAdmin {
+		t.Errorf("signed in as %+v", user)
+	}
+
+	stored, err := m.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.FirebaseUID != "uid-1" {
+		t.Errorf("uid was not linked, got %q", stored.FirebaseUID)
+	}
+	if stored.Name != "Jane" || stored.Password != "hash" || stored.Type != model.UserTypeAdmin {
+		t.Errorf("linking changed the user to %+v", stored)
+	}
+
+	// Signing in again with the linked uid works
+	if _, err := m.SignInWithFirebase(ctx, "id-token"); err != nil {
+		t.Errorf("second sign-in failed: %v", err)
+	}
+}
+
+func TestSignInWithFirebaseRejectsUnverifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	m := newFirebaseModule(t, firebaseToken("uid-1", "jane@example.com", false))
+
+	_, err := m.SignInWithFirebase(ctx, "id-token")
+	if errorType(err) != response.ErrNoValidUserFound {
+		t.Fatalf("got %v, want %v", err, response.ErrNoValidUserFound)
+	}
+	if _, err := m.GetUser(ctx, "jane@example.com"); err == nil {
+		t.Error("user was created for an unverified email")
+	}
+}
+
+func TestSignInWithFirebaseRejectsOtherUID(t *testing.T) {
+	ctx := context.Background()
+	existing := model.User{Name: "Jane", Email: "jane@example.com", Type: model.UserTypeMember, FirebaseUID: "uid-1"}
+	m := newFirebaseModule(t, firebaseToken("uid-2", "jane@example.com", true), existing)
+
+	_, err := m.SignInWithFirebase(ctx, "id-token")
+	if errorType(err) != response.ErrNoValidUserFound {
+		t.Fatalf("got %v, want %v", err, response.ErrNoValidUserFound)
+	}
+
+	stored, err := m.GetUser(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.FirebaseUID != "uid-1" {
+		t.Errorf("uid was relinked to %q", stored.FirebaseUID)
+	}
+}
+
+func TestSignInWithFirebaseRejectsInvalidToken(t *testing.T) {
+	m := newFirebaseModule(t, firebaseToken("uid-1", "jane@example.com", true))
+
+	_, err := m.SignInWithFirebase(context.Background(), "forged")
+	if errorType(err) != response.ErrNoValidUserFound {
+		t.Fatalf("got %v, want %v", err, response.ErrNoValidUserFound)
+	}
+}
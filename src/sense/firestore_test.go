@@ -0,0 +1,172 @@
+package sense
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"google.golang.org/api/option"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeFirestore is an in-memory Firestore backend for the document reads and writes the module does, i.e.
+// Get, Set (with merge), Update and Delete of single documents, and queries of one collection filtered by
+// equality. Other filters, ordering and limits are not supported.
+type fakeFirestore struct {
+	pb.UnimplementedFirestoreServer
+
+	mu   sync.Mutex
+	docs map[string]*pb.Document
+}
+
+// newTestFirestore starts a fakeFirestore and returns a client connected to it.
+func newTestFirestore(t *testing.T) *firestore.Client {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterFirestoreServer(server, &fakeFirestore{docs: map[string]*pb.Document{}})
+	go server.Serve(listener)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := firestore.NewClient(context.Background(), "test", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+		server.Stop()
+	})
+	return client
+}
+
+func (f *fakeFirestore) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	f.mu.Lock()
+	responses := make([]*pb.BatchGetDocumentsResponse, 0, len(req.Documents))
+	for _, name := range req.Documents {
+		res := &pb.BatchGetDocumentsResponse{ReadTime: timestamppb.Now()}
+		if doc, ok := f.docs[name]; ok {
+			res.Result = &pb.BatchGetDocumentsResponse_Found{Found: doc}
+		} else {
+			res.Result = &pb.BatchGetDocumentsResponse_Missing{Missing: name}
+		}
+		responses = append(responses, res)
+	}
+	f.mu.Unlock()
+
+	for _, res := range responses {
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeFirestore) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := timestamppb.Now()
+	res := &pb.CommitResponse{CommitTime: now}
+	for _, write := range req.Writes {
+		switch op := write.Operation.(type) {
+		case *pb.Write_Update:
+			name := op.Update.Name
+			existing, exists := f.docs[name]
+			if exists := write.GetCurrentDocument().GetExists(); write.GetCurrentDocument() != nil && exists != (existing != nil) {
+				return nil, status.Errorf(codes.NotFound, "document %s", name)
+			}
+
+			fields := op.Update.Fields
+			if write.UpdateMask != nil && exists {
+				// Only the masked top-level fields change, the rest is kept
+				fields = map[string]*pb.Value{}
+				for k, v := range existing.Fields {
+					fields[k] = v
+				}
+				for _, path := range write.UpdateMask.FieldPaths {
+					if v, ok := op.Update.Fields[path]; ok {
+						fields[path] = v
+					} else {
+						delete(fields, path)
+					}
+				}
+			}
+			f.docs[name] = &pb.Document{Name: name, Fields: fields, CreateTime: now, UpdateTime: now}
+		case *pb.Write_Delete:
+			delete(f.docs, op.Delete)
+		default:
+			return nil, status.Errorf(codes.Unimplemented, "write %T", op)
+		}
+		res.WriteResults = append(res.WriteResults, &pb.WriteResult{UpdateTime: now})
+	}
+	return res, nil
+}
+
+func (f *fakeFirestore) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	query := req.GetStructuredQuery()
+	if query == nil || len(query.From) != 1 || query.From[0].AllDescendants || len(query.OrderBy) > 0 || query.Limit != nil {
+		return status.Errorf(codes.Unimplemented, "query %v", query)
+	}
+	prefix := req.Parent + "/" + query.From[0].CollectionId + "/"
+
+	f.mu.Lock()
+	var docs []*pb.Document
+	for name, doc := range f.docs {
+		if !strings.HasPrefix(name, prefix) || strings.Contains(name[len(prefix):], "/") {
+			continue
+		}
+		ok, err := matchFilter(query.Where, doc)
+		if err != nil {
+			f.mu.Unlock()
+			return err
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	f.mu.Unlock()
+
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Name < docs[j].Name })
+	for _, doc := range docs {
+		if err := stream.Send(&pb.RunQueryResponse{Document: doc, ReadTime: timestamppb.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchFilter(filter *pb.StructuredQuery_Filter, doc *pb.Document) (bool, error) {
+	switch {
+	case filter == nil:
+		return true, nil
+	case filter.GetCompositeFilter() != nil:
+		for _, sub := range filter.GetCompositeFilter().Filters {
+			ok, err := matchFilter(sub, doc)
+			if !ok || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case filter.GetFieldFilter().GetOp() == pb.StructuredQuery_FieldFilter_EQUAL:
+		field := filter.GetFieldFilter()
+		return proto.Equal(doc.Fields[field.Field.FieldPath], field.Value), nil
+	}
+	return false, status.Errorf(codes.Unimplemented, "filter %v", filter)
+}
@@ -0,0 +1,130 @@
+package sense
+
+import (
+	"github.com/hansels/sense_backend/config"
+	"sync"
+	"time"
+)
+
+const guardPurgeSize = 10000
+
+// GuardPolicy describes how failed attempts on a key are throttled. After FreeAttempts failures every further
+// failure blocks the key for an exponentially growing delay, and after LockoutAttempts it is locked out.
+type GuardPolicy struct {
+	FreeAttempts    int
+	LockoutAttempts int
+	BackoffBase     time.Duration
+	BackoffMax      time.Duration
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+var (
+	AccountGuardPolicy = GuardPolicy{
+		FreeAttempts:    config.LoginFreeAttempts,
+		LockoutAttempts: config.LoginLockoutAttempts,
+		BackoffBase:     config.LoginBackoffBase,
+		BackoffMax:      config.LoginBackoffMax,
+		LockoutDuration: config.LoginLockoutDuration,
+		Window:          config.LoginAttemptWindow,
+	}
+	IPGuardPolicy = GuardPolicy{
+		FreeAttempts:    config.LoginIPFreeAttempts,
+		LockoutAttempts: config.LoginIPLockoutAttempts,
+		BackoffBase:     config.LoginBackoffBase,
+		BackoffMax:      config.LoginBackoffMax,
+		LockoutDuration: config.LoginLockoutDuration,
+		Window:          config.LoginAttemptWindow,
+	}
+)
+
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	blockedTill time.Time
+	window      time.Duration
+}
+
+// LoginGuard counts failed attempts per key, e.g. an account or a client IP, in memory.
+type LoginGuard struct {
+	mu       sync.Mutex
+	attempts map[string]*attempts
+}
+
+func NewLoginGuard() *LoginGuard {
+	return &LoginGuard{attempts: map[string]*attempts{}}
+}
+
+func AccountKey(email string) string {
+	return "account:" + email
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Wait returns how long the caller has to wait before any of the keys may be attempted again.
+func (g *LoginGuard) Wait(keys ...string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		a, ok := g.attempts[key]
+		if !ok {
+			continue
+		}
+		if d := a.blockedTill.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt on key and blocks it according to policy.
+func (g *LoginGuard) Fail(key string, policy GuardPolicy) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	a, ok := g.attempts[key]
+	if !ok || now.Sub(a.lastFailure) > policy.Window {
+		a = &attempts{window: policy.Window}
+		g.attempts[key] = a
+	}
+
+	a.failures++
+	a.lastFailure = now
+
+	switch {
+	case a.failures >= policy.LockoutAttempts:
+		a.blockedTill = now.Add(policy.LockoutDuration)
+	case a.failures > policy.FreeAttempts:
+		delay := policy.BackoffBase << uint(a.failures-policy.FreeAttempts-1)
+		if delay > policy.BackoffMax || delay <= 0 {
+			delay = policy.BackoffMax
+		}
+		a.blockedTill = now.Add(delay)
+	}
+
+	if len(g.attempts) > guardPurgeSize {
+		g.purge(now)
+	}
+}
+
+// Reset forgets the failed attempts of key, after a successful login or when an admin unlocks it.
+func (g *LoginGuard) Reset(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.attempts, key)
+}
+
+func (g *LoginGuard) purge(now time.Time) {
+	for key, a := range g.attempts {
+		if now.After(a.blockedTill) && now.Sub(a.lastFailure) > a.window {
+			delete(g.attempts, key)
+		}
+	}
+}
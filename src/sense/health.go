@@ -0,0 +1,240 @@
+package sense
+
+import (
+	"context"
+	"fmt"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	healthCollection = "health"
+	healthDocument   = "readyz"
+)
+
+// dependency checks one dependency, it should give up once ctx is done.
+type dependency struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func (m *Module) dependencies() []dependency {
+	return []dependency{
+		{name: "model", check: m.Model.WarmUp},
+		{name: "firestore", check: m.pingFirestore},
+		{name: "storage", check: m.Storage.Ping},
+	}
+}
+
+// Shutdown marks the module as shutting down, Readiness reports it is not ready from then on so load balancers
+// stop sending requests before the server closes.
+func (m *Module) Shutdown() {
+	atomic.StoreInt32(&m.shuttingDown, 1)
+}
+
+// readinessCache shares one round of checks between the probes that arrive while it runs and keeps its result
+// for config.ReadinessCacheTTL, so probing /readyz cannot multiply the load on the dependencies.
+type readinessCache struct {
+	mu        sync.Mutex
+	result    *model.Readiness
+	checkedAt time.Time
+	// done is closed when the running round finishes, nil while none runs
+	done chan struct{}
+	// busy are the dependencies whose check has not returned yet, possibly from an earlier round
+	busy map[string]bool
+}
+
+// Stop ends the job workers and the retention sweeper and waits, until ctx is done, for the jobs and data exports
+// in progress. Jobs still queued stay pending in Firestore and are picked up after the next start. It must be
+// called after the server stopped taking requests, and before Firestore is closed.
+func (m *Module) Stop(ctx context.Context) error {
+	m.stopOnce.Do(func() {
+		m.stopMu.Lock()
+		defer m.stopMu.Unlock()
+		close(m.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		m.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// errShuttingDown refuses work that would outlive the module, e.g. a data export started while it stops.
+var errShuttingDown = custerr.ErrChain{Message: "Server is shutting down, try again later", Type: response.ErrServiceUnavailable}
+
+// goBackground runs f in a goroutine Stop waits for. It returns false without running f once Stop was called.
+func (m *Module) goBackground(f func()) bool {
+	m.stopMu.Lock()
+	defer m.stopMu.Unlock()
+
+	select {
+	case <-m.stop:
+		return false
+	default:
+	}
+
+	m.background.Add(1)
+	go func() {
+		defer m.background.Done()
+		f()
+	}()
+	return true
+}
+
+// stopContext is a context with a timeout that is also cancelled by Stop.
+func (m *Module) stopContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	go func() {
+		select {
+		case <-m.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Readiness reports whether every dependency answered within config.ReadinessCheckTimeout. The module is ready
+// when all of them are ok and it is not shutting down.
+func (m *Module) Readiness(ctx context.Context) *model.Readiness {
+	if atomic.LoadInt32(&m.shuttingDown) == 1 {
+		return &model.Readiness{Status: model.ReadinessShuttingDown}
+	}
+
+	c := &m.readiness
+	c.mu.Lock()
+	if c.result != nil && time.Since(c.checkedAt) < config.ReadinessCacheTTL {
+		result := c.result
+		c.mu.Unlock()
+		return result
+	}
+	done := c.done
+	if done == nil {
+		done = make(chan struct{})
+		c.done = done
+		go m.checkReadiness(done)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return &model.Readiness{Status: model.ReadinessNotReady}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.result
+}
+
+// checkReadiness runs one round of checks concurrently. It does not depend on the probe that started it, the
+// others wait for it too.
+func (m *Module) checkReadiness(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ReadinessCheckTimeout)
+	defer cancel()
+
+	dependencies := m.dependencies()
+	readiness := &model.Readiness{Status: model.ReadinessReady, Checks: make([]model.DependencyCheck, len(dependencies))}
+
+	var wg sync.WaitGroup
+	for i, dep := range dependencies {
+		wg.Add(1)
+		go func(i int, dep dependency) {
+			defer wg.Done()
+			readiness.Checks[i] = m.checkDependency(ctx, dep)
+		}(i, dep)
+	}
+	wg.Wait()
+
+	for _, check := range readiness.Checks {
+		if check.Status != model.HealthStatusOK {
+			readiness.Status = model.ReadinessNotReady
+		}
+	}
+
+	c := &m.readiness
+	c.mu.Lock()
+	c.result = readiness
+	c.checkedAt = time.Now()
+	c.done = nil
+	c.mu.Unlock()
+	close(done)
+}
+
+// checkDependency runs a check until ctx is done. Checks that cannot be interrupted, like an inference, are left
+// to finish in the background, and are not started again until they did.
+func (m *Module) checkDependency(ctx context.Context, dep dependency) model.DependencyCheck {
+	check := model.DependencyCheck{Name: dep.name, Status: model.HealthStatusFailed}
+	if !m.readiness.start(dep.name) {
+		log.Warnf("Readiness check of %s is still running from an earlier round", dep.name)
+		return check
+	}
+
+	t := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer m.readiness.finish(dep.name)
+		done <- dep.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("no answer within %s", config.ReadinessCheckTimeout)
+	}
+
+	check.Latency = fmt.Sprintf("%.2f ms", time.Since(t).Seconds()*1000)
+	if err != nil {
+		log.Warnf("Readiness check of %s failed : %+v", dep.name, err)
+		return check
+	}
+	check.Status = model.HealthStatusOK
+	return check
+}
+
+func (c *readinessCache) start(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.busy[name] {
+		return false
+	}
+	if c.busy == nil {
+		c.busy = map[string]bool{}
+	}
+	c.busy[name] = true
+	return true
+}
+
+func (c *readinessCache) finish(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.busy, name)
+}
+
+// pingFirestore reads a document, that it does not exist still proves Firestore answers.
+func (m *Module) pingFirestore(ctx context.Context) error {
+	_, err := m.Firestore.Collection(healthCollection).Doc(healthDocument).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package sense
+
+import (
+	"context"
+	"github.com/fatih/structs"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/firebase"
+	"github.com/hansels/sense_backend/src/model"
+)
+
+const (
+	ImageVariantOriginal    = ""
+	ImageVariantThumbnail   = "thumbnail"
+	ImageVariantExplanation = "explanation"
+)
+
+// ImageURL returns a short lived signed URL for a variant of a prediction image.
+func (m *Module) ImageURL(ctx context.Context, userID string, id string, variant string) (string, error) {
+	prediction, err := m.authorizePrediction(ctx, userID, id)
+	if err != nil {
+		return "", err
+	}
+
+	var fileName string
+	switch variant {
+	case ImageVariantOriginal:
+		fileName = prediction.ID
+	case ImageVariantThumbnail:
+		fileName = firebase.ThumbnailName(prediction.ID)
+	case ImageVariantExplanation:
+		fileName = firebase.ExplanationName(prediction.ID)
+	default:
+		return "", ValidationError("variant", response.DetailUnknown, "Unknown image variant").SetField("variant", variant)
+	}
+
+	url, err := m.Storage.SignedURL(fileName, config.SignedURLExpiry)
+	if err != nil {
+		return "", custerr.ErrChain{Message: "Sign image url failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return url, nil
+}
+
+// RotateImageToken issues a new download token for every variant of a prediction image, invalidating the old
+// download URLs, and stores the new URLs on the prediction.
+func (m *Module) RotateImageToken(ctx context.Context, userID string, id string) (*model.Prediction, error) {
+	prediction, err := m.authorizePrediction(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := m.Storage.RotateToken(ctx, imageObjects(prediction.ID)...)
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Rotate image token failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	prediction.Image = m.Storage.GenerateURL(prediction.ID, token)
+	prediction.Thumbnail = m.Storage.GenerateURL(firebase.ThumbnailName(prediction.ID), token)
+	if prediction.Explanation != "" {
+		prediction.Explanation = m.Storage.GenerateURL(firebase.ExplanationName(prediction.ID), token)
+	}
+
+	err = m.updatePrediction(ctx, prediction)
+	if err != nil {
+		return nil, err
+	}
+	return prediction, nil
+}
+
+// RevokeImageToken removes the download token of every variant of a prediction image, after which it is only
+// reachable through ImageURL.
+func (m *Module) RevokeImageToken(ctx context.Context, userID string, id string) error {
+	prediction, err := m.authorizePrediction(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	err = m.Storage.RevokeToken(ctx, imageObjects(prediction.ID)...)
+	if err != nil {
+		return custerr.ErrChain{Message: "Revoke image token failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	prediction.Image = ""
+	prediction.Thumbnail = ""
+	prediction.Explanation = ""
+	return m.updatePrediction(ctx, prediction)
+}
+
+// authorizePrediction loads a prediction the user owns. Admins may access every prediction, anonymous
+// predictions are only reachable by admins.
+func (m *Module) authorizePrediction(ctx context.Context, userID string, id string) (*model.Prediction, error) {
+	prediction, err := m.GetPrediction(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if prediction.UserID != "" && prediction.UserID == userID {
+		return prediction, nil
+	}
+
+	user, err := m.GetUser(ctx, userID)
+	if err == nil && user.Type == model.UserTypeAdmin {
+		return prediction, nil
+	}
+
+	return nil, custerr.ErrChain{Message: "Prediction belongs to another user", Type: response.ErrForbiddenResource}
+}
+
+func (m *Module) updatePrediction(ctx context.Context, prediction *model.Prediction) error {
+	_, err := m.Firestore.Collection(predictionsCollection).Doc(prediction.ID).Set(ctx, structs.Map(prediction))
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+		return custerr.ErrChain{Message: "Save prediction failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+// imageObjects lists the storage objects of every variant of a prediction image.
+func imageObjects(id string) []string {
+	return []string{id, firebase.ThumbnailName(id), firebase.ExplanationName(id)}
+}
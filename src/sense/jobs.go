@@ -0,0 +1,319 @@
+package sense
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/structs"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/errors"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/metrics"
+	"github.com/hansels/sense_backend/common/reporter"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/model"
+	"github.com/hansels/sense_backend/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"time"
+)
+
+const jobsCollection = "prediction_jobs"
+
+// jobsQueued counts the jobs handed to the queue and not yet taken by a worker.
+var jobsQueued = metrics.NewGauge("sense_prediction_jobs_queued", "Prediction jobs waiting for a worker.")
+
+// EnqueuePredictionJob uploads the image and persists a pending job before handing it to the worker pool,
+// so the job survives a restart even if it was never picked up.
+func (m *Module) EnqueuePredictionJob(ctx context.Context, userID string, data []byte, explain bool, webhook string) (*model.PredictionJob, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, custerr.ErrChain{Message: "Generate job token failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	token := hex.EncodeToString(secret)
+
+	id, uploaded, err := m.uploadImage(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &model.PredictionJob{
+		ID:        id,
+		Status:    model.JobStatusPending,
+		UserID:    userID,
+		TokenHash: hashJobToken(token),
+		Image:     uploaded.URL,
+		Thumbnail: uploaded.ThumbnailURL,
+		Explain:   explain,
+		Webhook:   webhook,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = m.saveJob(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	// Counted before the send so a worker taking the job right away never makes the gauge negative
+	jobsQueued.Inc()
+	select {
+	case m.jobs <- job.ID:
+	default:
+		jobsQueued.Dec()
+		job.Status = model.JobStatusFailed
+		job.Error = "Job queue is full"
+		_ = m.saveJob(ctx, job)
+		return nil, custerr.ErrChain{Message: "Job queue is full", Type: response.ErrServiceUnavailable}
+	}
+
+	job.Token = token
+	return job, nil
+}
+
+// GetPredictionJobFor reads a job for the user who created it, or for anyone holding its token. Other callers are
+// told the job does not exist, so ids cannot be probed.
+func (m *Module) GetPredictionJobFor(ctx context.Context, id string, userID string, token string) (*model.PredictionJob, error) {
+	job, err := m.GetPredictionJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := userID != "" && job.UserID == userID
+	holder := token != "" && job.TokenHash != "" &&
+		subtle.ConstantTimeCompare([]byte(job.TokenHash), []byte(hashJobToken(token))) == 1
+	if !owner && !holder {
+		return nil, custerr.ErrChain{Message: "Job not found", Type: response.ErrNotFound}
+	}
+	return job, nil
+}
+
+func (m *Module) GetPredictionJob(ctx context.Context, id string) (*model.PredictionJob, error) {
+	ds, err := m.Firestore.Collection(jobsCollection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, custerr.ErrChain{Message: "Job not found", Cause: err, Type: response.ErrNotFound}
+	}
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read job failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	job := &model.PredictionJob{}
+	err = decodeDocument(ds.Data(), job)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Job Unmarshal Error : %+v", err)
+		return nil, err
+	}
+	return job, nil
+}
+
+// StartJobWorkers starts the prediction job worker pool and re-enqueues the jobs a previous run left unfinished.
+func (m *Module) StartJobWorkers() {
+	m.background.Add(config.PredictionJobWorkers + 1)
+	for i := 0; i < config.PredictionJobWorkers; i++ {
+		go m.runJobWorker()
+	}
+	go m.recoverJobs()
+}
+
+func (m *Module) recoverJobs() {
+	defer m.background.Done()
+
+	ctx, cancel := m.stopContext(time.Minute)
+	defer cancel()
+
+	docs, err := m.Firestore.Collection(jobsCollection).
+		Where("status", "in", []string{model.JobStatusPending, model.JobStatusRunning}).
+		Documents(ctx).GetAll()
+	if err != nil {
+		log.WithContext(ctx).Errorf("Recover Prediction Jobs Error : %+v", err)
+		return
+	}
+
+	for _, doc := range docs {
+		jobsQueued.Inc()
+		select {
+		case m.jobs <- doc.Ref.ID:
+		case <-m.stop:
+			jobsQueued.Dec()
+			return
+		}
+	}
+	log.WithContext(ctx).Infof("Recovered %d prediction jobs", len(docs))
+}
+
+// runJobWorker processes queued jobs until Stop, finishing the job it is on.
+func (m *Module) runJobWorker() {
+	defer m.background.Done()
+
+	for {
+		// A stopped worker must not pick up another job even when the queue is ready too
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		select {
+		case <-m.stop:
+			return
+		case id := <-m.jobs:
+			jobsQueued.Dec()
+			m.processJob(id)
+		}
+	}
+}
+
+func (m *Module) processJob(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.PredictionJobTimeout)
+	defer cancel()
+
+	job, err := m.GetPredictionJob(ctx, id)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Load Prediction Job %s Error : %+v", id, err)
+		return
+	}
+	if job.Status == model.JobStatusCompleted || job.Status == model.JobStatusFailed {
+		return
+	}
+
+	job.Status = model.JobStatusRunning
+	job.UpdatedAt = time.Now()
+	err = m.saveJob(ctx, job)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Save Prediction Job %s Error : %+v", id, err)
+		return
+	}
+
+	result, err := m.runJob(ctx, job)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Prediction Job %s Error : %+v", id, err)
+		job.Status = model.JobStatusFailed
+		job.Error = ErrorMessage(err)
+	} else {
+		job.Status = model.JobStatusCompleted
+		job.Result = result
+	}
+	job.UpdatedAt = time.Now()
+
+	err = m.saveJob(ctx, job)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Save Prediction Job %s Error : %+v", id, err)
+		return
+	}
+
+	if job.Webhook != "" {
+		m.notifyWebhook(job)
+	}
+}
+
+func (m *Module) runJob(ctx context.Context, job *model.PredictionJob) (result *model.PredictionResult, err error) {
+	// A panicking model must fail the job, not kill the worker
+	defer func() {
+		if recov := recover(); recov != nil {
+			// Taken while panicking, so the stack still holds the frames that panicked
+			message := fmt.Sprint("app panic due to ", recov)
+			err = errors.New(message)
+			reporter.Report(reporter.Event{
+				Message: message,
+				Panic:   true,
+				Route:   "prediction_job",
+				UserID:  job.UserID,
+				Fields:  map[string]interface{}{"job_id": job.ID, "panic": fmt.Sprint(recov)},
+				Stack:   reporter.Stack(err),
+				Time:    time.Now(),
+			})
+		}
+	}()
+
+	data, err := m.Storage.ReadImage(ctx, job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err = m.classify(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	result.ID = job.ID
+	result.Image = job.Image
+	result.Thumbnail = job.Thumbnail
+
+	if job.Explain {
+		err = m.explain(ctx, job.ID, data, result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = m.savePrediction(ctx, job.UserID, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// notifyWebhook posts the finished job to its webhook. The body is signed with HMAC-SHA256 of the
+// webhook secret so receivers can verify it came from us.
+func (m *Module) notifyWebhook(job *model.PredictionJob) {
+	body, err := json.Marshal(job.Public())
+	if err != nil {
+		log.Errorf("Webhook Marshal Error : %+v", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, job.Webhook, bytes.NewReader(body))
+	if err != nil {
+		log.Errorf("Webhook Request Error : %+v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sense-Signature", "sha256="+utils.GenerateSHA256(config.WebhookSecret, string(body)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		log.Errorf("Webhook Call Error for job %s : %+v", job.ID, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		log.Errorf("Webhook for job %s responded %s", job.ID, resp.Status)
+	}
+}
+
+// deleteUserJobs removes the prediction jobs of the user with their images, also those that never finished and so
+// have no prediction.
+func (m *Module) deleteUserJobs(ctx context.Context, userID string) error {
+	docs, err := m.Firestore.Collection(jobsCollection).Where("user_id", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		err = m.deletePrediction(ctx, doc.Ref.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Module) saveJob(ctx context.Context, job *model.PredictionJob) error {
+	_, err := m.Firestore.Collection(jobsCollection).Doc(job.ID).Set(ctx, structs.Map(job))
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+		return custerr.ErrChain{Message: "Save job failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+func hashJobToken(token string) string {
+	return utils.GenerateSHA256(config.PasswordSalt, token)
+}
@@ -0,0 +1,110 @@
+package sense
+
+import (
+	"context"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/errors"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/src/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// Authenticate checks an email and password while counting failures per account and per client IP. Unknown emails
+// take as long and fail the same way as a wrong password, so responses do not tell which accounts exist.
+func (m *Module) Authenticate(ctx context.Context, email string, password string, ip string) (*model.User, error) {
+	accountKey, ipKey := AccountKey(email), IPKey(ip)
+	if wait := m.LoginGuard.Wait(accountKey, ipKey); wait > 0 {
+		return nil, tooManyAttempts(wait)
+	}
+
+	user, err := m.GetUser(ctx, email)
+	if err != nil && status.Code(err) != codes.NotFound {
+		// An outage is not a failed attempt, it must not lock anyone out
+		return nil, custerr.ErrChain{Message: "Read user failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	if err != nil || user.Password == "" {
+		// Accounts created through Firebase sign-in have no password to check
+		_ = CheckPassword(passwordlessHash(), password)
+		return nil, m.failLogin(accountKey, ipKey, err)
+	}
+
+	err = CheckPassword(user.Password, password)
+	if err != nil {
+		return nil, m.failLogin(accountKey, ipKey, err)
+	}
+
+	m.LoginGuard.Reset(accountKey)
+	return user, nil
+}
+
+// CheckUser looks an email up without telling the caller whether the account exists, only errors and throttling
+// are returned. Lookups of unknown emails still count against the client IP, like failed logins do.
+func (m *Module) CheckUser(ctx context.Context, email string, ip string) error {
+	ipKey := IPKey(ip)
+	if wait := m.LoginGuard.Wait(ipKey); wait > 0 {
+		return tooManyAttempts(wait)
+	}
+
+	_, err := m.Firestore.Collection(usersCollection).Doc(email).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		m.LoginGuard.Fail(ipKey, IPGuardPolicy)
+		return nil
+	}
+	if err != nil {
+		return custerr.ErrChain{Message: "Read user failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+// UnlockLogin clears the failed attempts of an account and, when given, of a client IP.
+func (m *Module) UnlockLogin(email string, ip string) {
+	m.LoginGuard.Reset(AccountKey(email))
+	if ip != "" {
+		m.LoginGuard.Reset(IPKey(ip))
+	}
+	log.Infof("Login unlocked for %s %s", email, ip)
+}
+
+func (m *Module) failLogin(accountKey string, ipKey string, cause error) error {
+	m.LoginGuard.Fail(accountKey, AccountGuardPolicy)
+	m.LoginGuard.Fail(ipKey, IPGuardPolicy)
+	return custerr.ErrChain{Message: "Login Unsuccessful!", Cause: cause, Type: response.ErrNoValidUserFound}
+}
+
+func tooManyAttempts(wait time.Duration) error {
+	return custerr.ErrChain{
+		Message: "Too Many Attempts, Try Again Later!",
+		Type:    response.ErrTooManyRequests,
+	}.SetField("retry_after", seconds(wait))
+}
+
+// passwordlessHash is checked against when there is no real hash, so the request costs one bcrypt comparison
+// either way.
+func passwordlessHash() string {
+	dummyHashOnce.Do(func() {
+		hash, err := HashPassword(time.Now().String())
+		if err != nil {
+			log.Errorf("Dummy Hash Error : %+v", err)
+		}
+		dummyHash = hash
+	})
+	return dummyHash
+}
+
+// RetryAfter returns the seconds a throttled caller should wait, as carried by errors from Authenticate.
+func RetryAfter(err error) string {
+	var chain custerr.ErrChain
+	if errors.As(err, &chain) {
+		return chain.Fields["retry_after"]
+	}
+	return ""
+}
@@ -0,0 +1,187 @@
+package sense
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/errors"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/firebase"
+	"github.com/hansels/sense_backend/src/ml"
+	"github.com/hansels/sense_backend/src/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const predictionsCollection = "predictions"
+
+// PredictImage uploads an image to storage, normalized to JPEG, and runs the model on it. When explain is set a
+// saliency overlay of the verdict is uploaded as well. The prediction is recorded for userID, which is empty for
+// anonymous requests.
+func (m *Module) PredictImage(ctx context.Context, userID string, data []byte, explain bool) (*model.PredictionResult, error) {
+	id, uploaded, err := m.uploadImage(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := m.classify(ctx, uploaded.Data)
+	if err != nil {
+		m.discardImage(ctx, id)
+		return nil, err
+	}
+	result.ID = id
+	result.Image = uploaded.URL
+	result.Thumbnail = uploaded.ThumbnailURL
+
+	if explain {
+		err = m.explain(ctx, id, uploaded.Data, result)
+		if err != nil {
+			m.discardImage(ctx, id)
+			return nil, err
+		}
+	}
+
+	err = m.savePrediction(ctx, userID, result)
+	if err != nil {
+		m.discardImage(ctx, id)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// discardImage removes the objects of a prediction that failed after its image was uploaded, nothing refers to
+// them. The request may have timed out already, so the deletes get a context of their own.
+func (m *Module) discardImage(ctx context.Context, id string) {
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), config.UploadCleanupTimeout)
+	defer cancel()
+
+	err := m.Storage.DeleteObjects(cleanupCtx, imageObjects(id)...)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Discard Image %s Error : %+v", id, err)
+	}
+}
+
+func (m *Module) GetPrediction(ctx context.Context, id string) (*model.Prediction, error) {
+	ds, err := m.Firestore.Collection(predictionsCollection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, custerr.ErrChain{Message: "Prediction not found", Cause: err, Type: response.ErrNotFound}
+	}
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read prediction failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	prediction := &model.Prediction{}
+	err = decodeDocument(ds.Data(), prediction)
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read prediction failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return prediction, nil
+}
+
+// savePrediction records the prediction, its id is the object name of the uploaded image.
+func (m *Module) savePrediction(ctx context.Context, userID string, result *model.PredictionResult) error {
+	prediction := model.Prediction{
+		ID:          result.ID,
+		UserID:      userID,
+		Verdict:     result.Verdict,
+		IsDetected:  result.IsDetected,
+		Image:       result.Image,
+		Thumbnail:   result.Thumbnail,
+		Explanation: result.Explanation,
+		CreatedAt:   time.Now(),
+	}
+
+	return m.updatePrediction(ctx, &prediction)
+}
+
+func (m *Module) classify(ctx context.Context, data []byte) (*model.PredictionResult, error) {
+	outcome, err := m.Model.Predict(ctx, data)
+	if ctx.Err() != nil {
+		return nil, custerr.ErrChain{Message: "Prediction cancelled", Cause: ctx.Err(), Type: response.ErrTimeoutError}
+	}
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Prediction failed", Cause: err, Type: response.ErrBadRequest, Code: response.CodeInvalidImage}
+	}
+
+	result, err := generateResultFromML(outcome)
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Prediction failed", Cause: err, Type: response.ErrBadRequest, Code: response.CodeInvalidImage}
+	}
+
+	return result, nil
+}
+
+// uploadImage uploads an image to Storage Firebase under a new id.
+func (m *Module) uploadImage(ctx context.Context, data []byte) (string, *firebase.UploadedImage, error) {
+	// Random, job ids are handed out as the image id and must not be guessable
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", nil, custerr.ErrChain{Message: "Generate image id failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	uploadData := firebase.UploadImageData{
+		Ctx:      ctx,
+		FileName: id.String(),
+		File:     data,
+	}
+	uploaded, err := m.Storage.UploadImage(uploadData)
+	if errors.Is(err, firebase.ErrImageTooLarge) || errors.Is(err, firebase.ErrInvalidImage) {
+		return "", nil, imageError(err, "data")
+	}
+	if err != nil {
+		return "", nil, custerr.ErrChain{Message: "Upload image failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	// URL for Download Image, currently log for health checking
+	log.WithContext(ctx).Infoln(uploaded.URL)
+	return id.String(), uploaded, nil
+}
+
+// explain uploads a saliency overlay explaining the verdict, only on request since it runs the model many times
+func (m *Module) explain(ctx context.Context, id string, data []byte, result *model.PredictionResult) error {
+	saliency, err := m.Model.Explain(ctx, data)
+	if ctx.Err() != nil {
+		return custerr.ErrChain{Message: "Explain prediction cancelled", Cause: ctx.Err(), Type: response.ErrTimeoutError}
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("Explain Prediction Error : %+v", err)
+		return custerr.ErrChain{Message: "Explain prediction failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	explainData := firebase.UploadImageData{
+		Ctx:      ctx,
+		FileName: firebase.ExplanationName(id),
+		File:     saliency.Overlay,
+	}
+	result.Explanation, err = m.Storage.UploadFile(explainData, "image/png")
+	if err != nil {
+		return custerr.ErrChain{Message: "Upload explanation failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	return nil
+}
+
+// decodeDocument converts Firestore document data into a model through its json tags.
+func decodeDocument(data map[string]interface{}, to interface{}) error {
+	jsonString, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonString, to)
+}
+
+func generateResultFromML(outcome *ml.ObjectDetectionResponse) (*model.PredictionResult, error) {
+	if outcome.NumDetections == 0 {
+		return &model.PredictionResult{IsDetected: false}, nil
+	}
+
+	result := &model.PredictionResult{IsDetected: true}
+	result.Verdict = outcome.Detections[0].Label
+
+	return result, nil
+}
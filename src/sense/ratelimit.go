@@ -0,0 +1,80 @@
+package sense
+
+import (
+	"github.com/hansels/sense_backend/common/ratelimit"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/common/router"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/utils"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiters holds one limiter per route, created on first use from config.RateLimits.
+type rateLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*ratelimit.Limiter
+}
+
+func (l *rateLimiters) get(route string) *ratelimit.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limiters == nil {
+		l.limiters = map[string]*ratelimit.Limiter{}
+	}
+
+	limiter, ok := l.limiters[route]
+	if !ok {
+		limit, ok := config.RateLimits[route]
+		if !ok {
+			limit = config.DefaultRateLimit
+		}
+		limiter = ratelimit.New(limit.Requests, limit.Per, limit.Burst)
+		l.limiters[route] = limiter
+	}
+	return limiter
+}
+
+// RateLimit throttles each client of a route with a token bucket. Clients are told apart by the UserID set by
+// Authorize, which covers API keys, or else by address, so it goes inside Authorize or OptionalAuthorize.
+func (m *Module) RateLimit(h router.Handle) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+		client := r.Header.Get("UserID")
+		if client == "" {
+			client = IPKey(clientNetwork(utils.ClientIP(r)))
+		}
+
+		result := m.rateLimiters.get(r.Header.Get("routePath")).Allow(client)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", seconds(result.Reset))
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", seconds(result.RetryAfter))
+			return response.NewJSONResponse().SetError(response.ErrTooManyRequests).SetLog("retry_after", seconds(result.RetryAfter)).
+				SetMessage("Too Many Requests, Try Again Later!")
+		}
+
+		return h(w, r)
+	}
+}
+
+// clientNetwork is the address anonymous clients are limited by. ClientIP only follows X-Forwarded-For from
+// trusted proxies, so it cannot be made up. IPv6 clients usually get a whole /64 and are limited by it, or they
+// could pick a new address for every request.
+func clientNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() != nil {
+		return ip
+	}
+	return parsed.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
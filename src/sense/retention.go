@@ -0,0 +1,208 @@
+package sense
+
+import (
+	"cloud.google.com/go/firestore"
+	"context"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const retentionSweepTimeout = 10 * time.Minute
+
+// StartRetentionSweeper deletes prediction images and records older than their retention policy, once at
+// startup and then periodically until Stop.
+func (m *Module) StartRetentionSweeper() {
+	m.background.Add(1)
+	go func() {
+		defer m.background.Done()
+
+		ticker := time.NewTicker(config.RetentionSweepEvery)
+		defer ticker.Stop()
+
+		for {
+			m.sweep()
+			select {
+			case <-ticker.C:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// sweep is cancelled by Stop, what it did not get to is deleted by the next one.
+func (m *Module) sweep() {
+	ctx, cancel := m.stopContext(retentionSweepTimeout)
+	defer cancel()
+
+	deleted, err := m.SweepExpiredPredictions(ctx)
+	if err != nil {
+		log.WithContext(ctx).Errorf("Retention Sweep Error : %+v", err)
+		return
+	}
+	log.WithContext(ctx).Infof("Retention sweep deleted %d predictions", deleted)
+}
+
+// RetentionReport lists the predictions the next sweep would delete without deleting anything.
+func (m *Module) RetentionReport(ctx context.Context) (*model.RetentionReport, error) {
+	policies, err := m.userRetentionPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	expired, err := m.findExpiredPredictions(ctx, policies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.RetentionReport{
+		GlobalRetentionDays: config.ImageRetentionDays,
+		UserRetentionDays:   policies,
+		Expired:             expired,
+		Total:               len(expired),
+	}, nil
+}
+
+func (m *Module) SweepExpiredPredictions(ctx context.Context) (int, error) {
+	report, err := m.RetentionReport(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, expired := range report.Expired {
+		err = m.deletePrediction(ctx, expired.ID)
+		if err != nil {
+			log.WithContext(ctx).Errorf("Delete Expired Prediction %s Error : %+v", expired.ID, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// SetUserRetention overrides the global retention policy for a user, 0 falls back to the global policy.
+func (m *Module) SetUserRetention(ctx context.Context, userID string, days int) error {
+	if days < 0 {
+		return ValidationError("days", response.DetailInvalid, "Retention days must not be negative")
+	}
+
+	_, err := m.Firestore.Collection(usersCollection).Doc(userID).Update(ctx, []firestore.Update{{Path: "retention_days", Value: days}})
+	if status.Code(err) == codes.NotFound {
+		return custerr.ErrChain{Message: "User not found", Cause: err, Type: response.ErrNotFound}
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+		return custerr.ErrChain{Message: "Save retention failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+// DeleteUserImages deletes every image the user uploaded together with its prediction records.
+func (m *Module) DeleteUserImages(ctx context.Context, userID string) (int, error) {
+	if userID == "" {
+		return 0, custerr.ErrChain{Message: "User is required", Type: response.ErrBadRequest}
+	}
+
+	docs, err := m.Firestore.Collection(predictionsCollection).Where("user_id", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, custerr.ErrChain{Message: "Read predictions failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	for i, doc := range docs {
+		err = m.deletePrediction(ctx, doc.Ref.ID)
+		if err != nil {
+			return i, custerr.ErrChain{Message: "Delete prediction failed", Cause: err, Type: response.ErrInternalServerError}
+		}
+	}
+	return len(docs), nil
+}
+
+func (m *Module) userRetentionPolicies(ctx context.Context) (map[string]int, error) {
+	docs, err := m.Firestore.Collection(usersCollection).Where("retention_days", ">", 0).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read retention policies failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	policies := map[string]int{}
+	for _, doc := range docs {
+		var user model.User
+		err = decodeDocument(doc.Data(), &user)
+		if err != nil {
+			log.WithContext(ctx).Errorf("User Unmarshal Error : %+v", err)
+			continue
+		}
+		policies[doc.Ref.ID] = user.RetentionDays
+	}
+	return policies, nil
+}
+
+// findExpiredPredictions only queries predictions older than the shortest policy, anything newer cannot be
+// expired. A policy of 0 days keeps images forever.
+func (m *Module) findExpiredPredictions(ctx context.Context, policies map[string]int) ([]model.ExpiredPrediction, error) {
+	shortest := config.ImageRetentionDays
+	for _, days := range policies {
+		if shortest == 0 || days < shortest {
+			shortest = days
+		}
+	}
+	if shortest == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	docs, err := m.Firestore.Collection(predictionsCollection).
+		Where("created_at", "<", now.AddDate(0, 0, -shortest)).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read predictions failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	expired := []model.ExpiredPrediction{}
+	for _, doc := range docs {
+		var prediction model.Prediction
+		err = decodeDocument(doc.Data(), &prediction)
+		if err != nil {
+			log.WithContext(ctx).Errorf("Prediction Unmarshal Error : %+v", err)
+			continue
+		}
+
+		days := config.ImageRetentionDays
+		if userDays, ok := policies[prediction.UserID]; ok {
+			days = userDays
+		}
+		if days == 0 || !prediction.CreatedAt.Before(now.AddDate(0, 0, -days)) {
+			continue
+		}
+
+		expired = append(expired, model.ExpiredPrediction{
+			ID:            doc.Ref.ID,
+			UserID:        prediction.UserID,
+			CreatedAt:     prediction.CreatedAt,
+			RetentionDays: days,
+		})
+	}
+	return expired, nil
+}
+
+// deletePrediction removes every variant of a prediction image and the records referring to it.
+func (m *Module) deletePrediction(ctx context.Context, id string) error {
+	err := m.Storage.DeleteObjects(ctx, imageObjects(id)...)
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range []string{feedbackCollection, jobsCollection, predictionsCollection} {
+		_, err = m.Firestore.Collection(collection).Doc(id).Delete(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package sense
 
 import (
 	"cloud.google.com/go/firestore"
+	"context"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/hansels/sense_backend/common/errors"
 	"github.com/hansels/sense_backend/common/response"
@@ -9,27 +10,59 @@ import (
 	"github.com/hansels/sense_backend/config"
 	"github.com/hansels/sense_backend/src/firebase"
 	"github.com/hansels/sense_backend/src/ml"
+	"github.com/hansels/sense_backend/src/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"net/http"
 	"strings"
+	"sync"
 )
 
 type Opts struct {
 	Firestore *firestore.Client
 	Storage   *firebase.Storage
 	Model     *ml.Coco
+	Verifier  TokenVerifier
 }
 
 type Module struct {
 	Firestore *firestore.Client
 	Storage   *firebase.Storage
 	Model     *ml.Coco
+	Verifier  TokenVerifier
+
+	LoginGuard *LoginGuard
+
+	rateLimiters rateLimiters
+
+	jobs chan string
+
+	// stop is closed by Stop, background tracks the goroutines it waits for. stopMu keeps work from being added
+	// to background once Stop may be waiting for it.
+	stop       chan struct{}
+	stopOnce   sync.Once
+	stopMu     sync.Mutex
+	background sync.WaitGroup
+
+	readiness    readinessCache
+	shuttingDown int32
 }
 
 const authPrefix string = "Bearer "
 const authPrefixLower string = "bearer "
 
 func New(opts *Opts) *Module {
-	return &Module{Firestore: opts.Firestore, Storage: opts.Storage, Model: opts.Model}
+	return &Module{
+		Firestore: opts.Firestore,
+		Storage:   opts.Storage,
+		Model:     opts.Model,
+		Verifier:  opts.Verifier,
+
+		LoginGuard: NewLoginGuard(),
+
+		jobs: make(chan string, config.PredictionJobQueue),
+		stop: make(chan struct{}),
+	}
 }
 
 func getBearerToken(r *http.Request) string {
@@ -52,10 +85,45 @@ func (m *Module) Authorize(h router.Handle) router.Handle {
 	}
 }
 
+// OptionalAuthorize identifies the user when a token or API key is sent but lets anonymous requests through.
+func (m *Module) OptionalAuthorize(h router.Handle) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+		r.Header.Del("UserID")
+		if getBearerToken(r) == "" && r.Header.Get(apiKeyHeader) == "" {
+			return h(w, r)
+		}
+
+		return m.Authorize(h)(w, r)
+	}
+}
+
+// AuthorizeAdmin only lets through users of type Admin.
+func (m *Module) AuthorizeAdmin(h router.Handle) router.Handle {
+	return m.Authorize(m.RequireAdmin(h))
+}
+
+// RequireAdmin only lets through users of type Admin, for routes Authorize already ran for. API keys never pass,
+// they do not belong to a user.
+func (m *Module) RequireAdmin(h router.Handle) router.Handle {
+	return func(w http.ResponseWriter, r *http.Request) *response.JSONResponse {
+		user, err := m.GetUser(r.Context(), r.Header.Get("UserID"))
+		if err != nil || user.Type != model.UserTypeAdmin {
+			return response.NewJSONResponse().SetError(response.ErrForbiddenResource).SetLog("error", err).SetMessage("Unauthorized Access!")
+		}
+
+		return h(w, r)
+	}
+}
+
 func (m *Module) GetAuthorization(r *http.Request) (string, error) {
 	var token string
 	var authorized bool
 
+	// Services authenticate with an API key instead of a user token
+	if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" && getBearerToken(r) == "" {
+		return m.GetAuthorizationFromAPIKey(r.Context(), apiKey, r.Header.Get("routePath"))
+	}
+
 	authToken := getBearerToken(r)
 	if authToken != "" {
 		authorized = true
@@ -66,7 +134,7 @@ func (m *Module) GetAuthorization(r *http.Request) (string, error) {
 		return "", errors.New("Token is required!")
 	}
 
-	userId, err := m.GetAuthorizationFromToken(token)
+	userId, err := m.GetAuthorizationFromToken(r.Context(), token)
 	if err != nil {
 		return "", err
 	}
@@ -74,7 +142,8 @@ func (m *Module) GetAuthorization(r *http.Request) (string, error) {
 	return userId, nil
 }
 
-func (m *Module) GetAuthorizationFromToken(tokenString string) (string, error) {
+// GetAuthorizationFromToken returns the user of a token, as long as the user exists and has not revoked it.
+func (m *Module) GetAuthorizationFromToken(ctx context.Context, tokenString string) (string, error) {
 	if tokenString == "" {
 		return "", errors.New("Token should not be empty!")
 	}
@@ -105,6 +174,20 @@ func (m *Module) GetAuthorizationFromToken(tokenString string) (string, error) {
 		return "", err
 	}
 
+	user, err := m.GetUser(ctx, userId)
+	if status.Code(err) == codes.NotFound {
+		return "", errors.New("Token Unauthorized")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Tokens issued before iat was added have none and only pass while nothing was revoked
+	iat, _ := claims["iat"].(float64)
+	if int64(iat) < user.TokensValidAfter {
+		return "", errors.New("Token Revoked")
+	}
+
 	return userId, nil
 }
 
@@ -0,0 +1,87 @@
+package sense
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	"context"
+	"github.com/hansels/sense_backend/src/firebase"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// memBucket keeps the objects of a test in memory.
+type memBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStorage() (*firebase.Storage, *memBucket) {
+	bucket := &memBucket{objects: map[string][]byte{}}
+	return &firebase.Storage{FirebaseStorage: bucket}, bucket
+}
+
+func (b *memBucket) put(names ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, name := range names {
+		b.objects[name] = []byte(name)
+	}
+}
+
+func (b *memBucket) names() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := make([]string, 0, len(b.objects))
+	for name := range b.objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type memWriter struct {
+	bytes.Buffer
+	bucket *memBucket
+	name   string
+}
+
+func (w *memWriter) Close() error {
+	w.bucket.mu.Lock()
+	defer w.bucket.mu.Unlock()
+	w.bucket.objects[w.name] = w.Bytes()
+	return nil
+}
+
+func (b *memBucket) NewWriter(ctx context.Context, name string, contentType string, metadata map[string]string) io.WriteCloser {
+	return &memWriter{bucket: b, name: name}
+}
+
+func (b *memBucket) NewReader(ctx context.Context, name string) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.objects[name]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *memBucket) Delete(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.objects[name]; !ok {
+		return storage.ErrObjectNotExist
+	}
+	delete(b.objects, name)
+	return nil
+}
+
+func (b *memBucket) UpdateMetadata(ctx context.Context, name string, metadata map[string]string) error {
+	return nil
+}
+
+func (b *memBucket) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package sense
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890".
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists eight digits, the six digit codes are their last six
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if code := totpCode(key, tt.unix/totpPeriod); code != tt.code {
+			t.Errorf("code at %d is %s, want %s", tt.unix, code, tt.code)
+		}
+
+		step, ok := verifyTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0), 0)
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("code %s at %d verified as step %d, %v", tt.code, tt.unix, step, ok)
+		}
+	}
+}
+
+func TestVerifyTOTPClockSkew(t *testing.T) {
+	key, _ := totpEncoding.DecodeString(rfc6238Secret)
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{name: "current", step: current, valid: true},
+		{name: "one behind", step: current - 1, valid: true},
+		{name: "one ahead", step: current + 1, valid: true},
+		{name: "two behind", step: current - 2},
+		{name: "two ahead", step: current + 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := verifyTOTP(rfc6238Secret, totpCode(key, tt.step), now, 0)
+			if ok != tt.valid || (ok && step != tt.step) {
+				t.Errorf("verified as step %d, %v", step, ok)
+			}
+		})
+	}
+}
+
+func TestVerifyTOTPRejectsReusedCode(t *testing.T) {
+	key, _ := totpEncoding.DecodeString(rfc6238Secret)
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	code := totpCode(key, current)
+
+	step, ok := verifyTOTP(rfc6238Secret, code, now, 0)
+	if !ok {
+		t.Fatal("fresh code rejected")
+	}
+	if _, ok := verifyTOTP(rfc6238Secret, code, now, step); ok {
+		t.Error("code accepted twice")
+	}
+	if _, ok := verifyTOTP(rfc6238Secret, code, now.Add(totpPeriod*time.Second), step); ok {
+		t.Error("code accepted again in the next step")
+	}
+	// An earlier code still inside the window is as used as the one that was accepted
+	if _, ok := verifyTOTP(rfc6238Secret, totpCode(key, current-1), now, step); ok {
+		t.Error("older code accepted after a newer one")
+	}
+	if _, ok := verifyTOTP(rfc6238Secret, totpCode(key, current+1), now, step); !ok {
+		t.Error("next code rejected")
+	}
+}
+
+func TestVerifyTOTPInput(t *testing.T) {
+	key, _ := totpEncoding.DecodeString(rfc6238Secret)
+	now := time.Unix(1234567890, 0)
+	code := totpCode(key, now.Unix()/totpPeriod)
+
+	if _, ok := verifyTOTP(strings.ToLower(rfc6238Secret), " "+code+"\n", now, 0); !ok {
+		t.Error("lowercase secret or padded code rejected")
+	}
+	for _, bad := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := verifyTOTP(rfc6238Secret, bad, now, 0); ok {
+			t.Errorf("code %q accepted", bad)
+		}
+	}
+	if _, ok := verifyTOTP("not base32!", code, now, 0); ok {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := newRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 11 || code[5] != '-' {
+		t.Errorf("recovery code is %q", code)
+	}
+	if normalizeRecoveryCode(" "+strings.ToUpper(code)+" ") != strings.Replace(code, "-", "", 1) {
+		t.Errorf("%q does not normalize", code)
+	}
+}
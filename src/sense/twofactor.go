@@ -0,0 +1,272 @@
+package sense
+
+import (
+	"context"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/fatih/structs"
+	"github.com/hansels/sense_backend/common/custerr"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/model"
+	"github.com/hansels/sense_backend/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const (
+	twoFactorCollection = "two_factor"
+
+	// challengeIssuer differs from the session issuer, so CheckClaims never accepts a challenge token as a login
+	challengeIssuer = "Sense-2FA"
+)
+
+// EnrollTwoFactor generates a new TOTP secret for the user. It only takes effect after ConfirmTwoFactor.
+func (m *Module) EnrollTwoFactor(ctx context.Context, userID string) (*model.TwoFactorEnrollment, error) {
+	current, err := m.getTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.Enabled {
+		return nil, custerr.ErrChain{Message: "Two-factor authentication is already enabled", Type: response.ErrBadRequest}
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Generate secret failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	err = m.saveTwoFactor(ctx, &model.TwoFactor{UserID: userID, Secret: secret, RecoveryCodes: []string{}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TwoFactorEnrollment{Secret: secret, URI: totpURI(config.TOTPIssuer, userID, secret)}, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the user proves their app produces valid codes, and
+// returns the recovery codes. Only their hashes are kept, so they cannot be shown again. Wrong codes count
+// against the account like failed logins.
+func (m *Module) ConfirmTwoFactor(ctx context.Context, userID string, code string, ip string) ([]string, error) {
+	key, ipKey := "2fa:"+userID, IPKey(ip)
+	if wait := m.LoginGuard.Wait(key, ipKey); wait > 0 {
+		return nil, tooManyAttempts(wait)
+	}
+
+	twoFactor, err := m.getTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor == nil {
+		return nil, custerr.ErrChain{Message: "Two-factor authentication is not enrolled", Type: response.ErrBadRequest}
+	}
+	if twoFactor.Enabled {
+		return nil, custerr.ErrChain{Message: "Two-factor authentication is already enabled", Type: response.ErrBadRequest}
+	}
+
+	step, ok := verifyTOTP(twoFactor.Secret, code, time.Now(), twoFactor.LastStep)
+	if !ok {
+		m.LoginGuard.Fail(key, AccountGuardPolicy)
+		m.LoginGuard.Fail(ipKey, IPGuardPolicy)
+		return nil, custerr.ErrChain{Message: "Invalid code", Type: response.ErrBadRequest, Code: response.CodeInvalidTwoFactor}
+	}
+	m.LoginGuard.Reset(key)
+
+	codes := make([]string, 0, config.TOTPRecoveryCodes)
+	hashes := make([]string, 0, config.TOTPRecoveryCodes)
+	for i := 0; i < config.TOTPRecoveryCodes; i++ {
+		recoveryCode, err := newRecoveryCode()
+		if err != nil {
+			return nil, custerr.ErrChain{Message: "Generate recovery codes failed", Cause: err, Type: response.ErrInternalServerError}
+		}
+		codes = append(codes, recoveryCode)
+		hashes = append(hashes, hashRecoveryCode(recoveryCode))
+	}
+
+	twoFactor.Enabled = true
+	twoFactor.LastStep = step
+	twoFactor.RecoveryCodes = hashes
+	err = m.saveTwoFactor(ctx, twoFactor)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor removes the enrollment after checking the password and, once enabled, a TOTP code or an unused
+// recovery code, so a stolen session and password are not enough. Failures count against the account like
+// failed logins.
+func (m *Module) DisableTwoFactor(ctx context.Context, userID string, password string, code string, ip string) error {
+	key, ipKey := "2fa:"+userID, IPKey(ip)
+	if wait := m.LoginGuard.Wait(key, ipKey); wait > 0 {
+		return tooManyAttempts(wait)
+	}
+
+	user, err := m.GetUser(ctx, userID)
+	if err != nil {
+		return custerr.ErrChain{Message: "User not found", Cause: err, Type: response.ErrNotFound}
+	}
+
+	err = CheckPassword(user.Password, password)
+	if err != nil {
+		m.LoginGuard.Fail(key, AccountGuardPolicy)
+		m.LoginGuard.Fail(ipKey, IPGuardPolicy)
+		return custerr.ErrChain{Message: "Password is incorrect", Type: response.ErrForbiddenResource}
+	}
+
+	twoFactor, err := m.getTwoFactor(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if twoFactor != nil && twoFactor.Enabled {
+		if _, ok := verifyTOTP(twoFactor.Secret, code, time.Now(), twoFactor.LastStep); !ok && !consumeRecoveryCode(twoFactor, code) {
+			m.LoginGuard.Fail(key, AccountGuardPolicy)
+			m.LoginGuard.Fail(ipKey, IPGuardPolicy)
+			return custerr.ErrChain{Message: "Invalid code", Type: response.ErrForbiddenResource, Code: response.CodeInvalidTwoFactor}
+		}
+	}
+
+	m.LoginGuard.Reset(key)
+	err = m.deleteTwoFactor(ctx, userID)
+	if err != nil {
+		return custerr.ErrChain{Message: "Disable two-factor authentication failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+// TwoFactorEnabled reports whether logging in as the user needs a TOTP code.
+func (m *Module) TwoFactorEnabled(ctx context.Context, userID string) (bool, error) {
+	twoFactor, err := m.getTwoFactor(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return twoFactor != nil && twoFactor.Enabled, nil
+}
+
+// TwoFactorChallenge issues the short-lived token that stands for a verified password until the TOTP code is.
+func (m *Module) TwoFactorChallenge(userID string) (string, error) {
+	claims := jwt.MapClaims{
+		"email": userID,
+		"iss":   challengeIssuer,
+		"exp":   time.Now().Add(config.TwoFactorChallengeExpiry).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.SignatureKey)
+}
+
+// VerifyTwoFactor completes a login with the challenge token and either a TOTP code or an unused recovery code.
+// Failed codes count against the account like failed passwords.
+func (m *Module) VerifyTwoFactor(ctx context.Context, challenge string, code string, ip string) (*model.User, error) {
+	userID, err := parseChallenge(challenge)
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Challenge expired, please log in again", Cause: err, Type: response.ErrForbiddenResource}
+	}
+
+	key, ipKey := "2fa:"+userID, IPKey(ip)
+	if wait := m.LoginGuard.Wait(key, ipKey); wait > 0 {
+		return nil, tooManyAttempts(wait)
+	}
+
+	twoFactor, err := m.getTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor == nil || !twoFactor.Enabled {
+		return nil, custerr.ErrChain{Message: "Two-factor authentication is not enabled", Type: response.ErrBadRequest}
+	}
+
+	if step, ok := verifyTOTP(twoFactor.Secret, code, time.Now(), twoFactor.LastStep); ok {
+		twoFactor.LastStep = step
+	} else if !consumeRecoveryCode(twoFactor, code) {
+		m.LoginGuard.Fail(key, AccountGuardPolicy)
+		m.LoginGuard.Fail(ipKey, IPGuardPolicy)
+		return nil, custerr.ErrChain{Message: "Invalid code", Type: response.ErrNoValidUserFound, Code: response.CodeInvalidTwoFactor}
+	}
+
+	// Saving before the login succeeds is what makes the code single use
+	err = m.saveTwoFactor(ctx, twoFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := m.GetUser(ctx, userID)
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "User not found", Cause: err, Type: response.ErrNotFound}
+	}
+
+	m.LoginGuard.Reset(key)
+	return user, nil
+}
+
+func parseChallenge(challenge string) (string, error) {
+	token, err := jwt.Parse(challenge, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("Signing method invalid")
+		}
+		return config.SignatureKey, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["iss"] != challengeIssuer {
+		return "", errors.New("Token Unauthorized")
+	}
+
+	userID, _ := claims["email"].(string)
+	if userID == "" {
+		return "", errors.New("Token Unauthorized")
+	}
+	return userID, nil
+}
+
+func consumeRecoveryCode(twoFactor *model.TwoFactor, code string) bool {
+	hash := hashRecoveryCode(code)
+	for i, stored := range twoFactor.RecoveryCodes {
+		if stored == hash {
+			twoFactor.RecoveryCodes = append(twoFactor.RecoveryCodes[:i], twoFactor.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func hashRecoveryCode(code string) string {
+	return utils.GenerateSHA256(config.PasswordSalt, normalizeRecoveryCode(code))
+}
+
+func (m *Module) getTwoFactor(ctx context.Context, userID string) (*model.TwoFactor, error) {
+	ds, err := m.Firestore.Collection(twoFactorCollection).Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, custerr.ErrChain{Message: "Read two-factor settings failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+
+	twoFactor := &model.TwoFactor{}
+	err = decodeDocument(ds.Data(), twoFactor)
+	if err != nil {
+		log.WithContext(ctx).Errorf("TwoFactor Unmarshal Error : %+v", err)
+		return nil, custerr.ErrChain{Message: "Read two-factor settings failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return twoFactor, nil
+}
+
+func (m *Module) saveTwoFactor(ctx context.Context, twoFactor *model.TwoFactor) error {
+	twoFactor.UpdatedAt = time.Now()
+
+	_, err := m.Firestore.Collection(twoFactorCollection).Doc(twoFactor.UserID).Set(ctx, structs.Map(twoFactor))
+	if err != nil {
+		log.WithContext(ctx).Errorf("Write to Firestore error : %+v", err)
+		return custerr.ErrChain{Message: "Save two-factor settings failed", Cause: err, Type: response.ErrInternalServerError}
+	}
+	return nil
+}
+
+func (m *Module) deleteTwoFactor(ctx context.Context, userID string) error {
+	_, err := m.Firestore.Collection(twoFactorCollection).Doc(userID).Delete(ctx)
+	return err
+}
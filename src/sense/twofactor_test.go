@@ -0,0 +1,118 @@
+package sense
+
+import (
+	"context"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"testing"
+	"time"
+)
+
+func newTwoFactorModule(t *testing.T) *Module {
+	t.Helper()
+	return &Module{Firestore: newTestFirestore(t), LoginGuard: NewLoginGuard()}
+}
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, time.Now().Unix()/totpPeriod)
+}
+
+func TestConfirmTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	m := newTwoFactorModule(t)
+
+	enrollment, err := m.EnrollTwoFactor(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := currentCode(t, enrollment.Secret)
+
+	recoveryCodes, err := m.ConfirmTwoFactor(ctx, "jane@example.com", code, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveryCodes) != config.TOTPRecoveryCodes {
+		t.Errorf("got %d recovery codes", len(recoveryCodes))
+	}
+
+	twoFactor, err := m.getTwoFactor(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !twoFactor.Enabled || twoFactor.LastStep == 0 {
+		t.Errorf("stored %+v", twoFactor)
+	}
+	for i, hash := range twoFactor.RecoveryCodes {
+		if hash == recoveryCodes[i] || hash != hashRecoveryCode(recoveryCodes[i]) {
+			t.Errorf("recovery code %d is stored as %q", i, hash)
+		}
+	}
+
+	if _, err := m.ConfirmTwoFactor(ctx, "jane@example.com", code, "10.0.0.1"); errorType(err) != response.ErrBadRequest {
+		t.Errorf("confirming twice got %v", err)
+	}
+}
+
+func TestConfirmTwoFactorThrottlesWrongCodes(t *testing.T) {
+	ctx := context.Background()
+	m := newTwoFactorModule(t)
+
+	enrollment, err := m.EnrollTwoFactor(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if wrong == currentCode(t, enrollment.Secret) {
+		wrong = "111111"
+	}
+
+	for i := 0; i < config.LoginFreeAttempts; i++ {
+		_, err := m.ConfirmTwoFactor(ctx, "jane@example.com", wrong, "10.0.0.1")
+		if errorType(err) != response.ErrBadRequest {
+			t.Fatalf("attempt %d got %v", i+1, err)
+		}
+	}
+	if _, err := m.ConfirmTwoFactor(ctx, "jane@example.com", wrong, "10.0.0.1"); errorType(err) != response.ErrBadRequest {
+		t.Fatalf("attempt over the free ones got %v", err)
+	}
+
+	// Blocked now, even with the right code and from another address
+	_, err = m.ConfirmTwoFactor(ctx, "jane@example.com", currentCode(t, enrollment.Secret), "10.0.0.2")
+	if errorType(err) != response.ErrTooManyRequests || RetryAfter(err) == "" {
+		t.Fatalf("blocked attempt got %v", err)
+	}
+	if enabled, _ := m.TwoFactorEnabled(ctx, "jane@example.com"); enabled {
+		t.Error("two-factor authentication enabled while blocked")
+	}
+}
+
+func TestConfirmTwoFactorSuccessResetsFailures(t *testing.T) {
+	ctx := context.Background()
+	m := newTwoFactorModule(t)
+
+	enrollment, err := m.EnrollTwoFactor(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < config.LoginFreeAttempts; i++ {
+		_, _ = m.ConfirmTwoFactor(ctx, "jane@example.com", "not a code", "10.0.0.1")
+	}
+
+	if _, err := m.ConfirmTwoFactor(ctx, "jane@example.com", currentCode(t, enrollment.Secret), "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if wait := m.LoginGuard.Wait("2fa:jane@example.com"); wait > 0 {
+		t.Errorf("account still blocked for %s", wait)
+	}
+	m.LoginGuard.mu.Lock()
+	_, ok := m.LoginGuard.attempts["2fa:jane@example.com"]
+	m.LoginGuard.mu.Unlock()
+	if ok {
+		t.Error("failures kept after the code was confirmed")
+	}
+}
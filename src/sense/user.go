@@ -0,0 +1,45 @@
+package sense
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/config"
+	"github.com/hansels/sense_backend/src/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const usersCollection = "users"
+
+func (m *Module) GetUser(ctx context.Context, id string) (*model.User, error) {
+	ds, err := m.Firestore.Collection(usersCollection).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonString, err := json.Marshal(ds.Data())
+	if err != nil {
+		log.WithContext(ctx).Errorf("User Marshal Error : %+v", err)
+		return nil, err
+	}
+
+	user := &model.User{}
+	err = json.Unmarshal(jsonString, &user)
+	if err != nil {
+		log.WithContext(ctx).Errorf("User Unmarshal Error : %+v", err)
+		return nil, err
+	}
+	return user, nil
+}
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(config.PasswordSalt+password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func CheckPassword(hash string, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(config.PasswordSalt+password))
+}
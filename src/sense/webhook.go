@@ -0,0 +1,110 @@
+package sense
+
+import (
+	"context"
+	"fmt"
+	"github.com/hansels/sense_backend/common/response"
+	"github.com/hansels/sense_backend/config"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// webhookClient only connects to public addresses and does not follow redirects, so a webhook cannot be pointed,
+// directly or through DNS, at the metadata server or anything else inside the network.
+var webhookClient = &http.Client{
+	Timeout: config.WebhookTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: config.WebhookTimeout,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: config.WebhookTimeout,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// nonPublicNetworks are the ranges a webhook must not reach.
+var nonPublicNetworks = parseNetworks(
+	"0.0.0.0/8",      // this network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, e.g. the metadata server
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // IETF protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved, and broadcast
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // IPv4 translation
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly checks the address actually dialed, after DNS, so a host cannot resolve to a public address when
+// validated and a private one when called.
+func dialPublicOnly(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicIP(net.ParseIP(host)) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+// ValidateWebhook accepts https URLs whose host only resolves to public addresses.
+func (m *Module) ValidateWebhook(ctx context.Context, webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return ValidationError("webhook", response.DetailInvalid, "Webhook must be an https URL")
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ValidationError("webhook", response.DetailInvalid, "Webhook host cannot be resolved")
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return ValidationError("webhook", response.DetailInvalid, "Webhook host must be a public address")
+		}
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"github.com/hansels/sense_backend/src/sense"
 	"github.com/hansels/sense_backend/src/sense/api"
 	"github.com/julienschmidt/httprouter"
@@ -17,11 +18,12 @@ type Opts struct {
 
 type Handler struct {
 	options     *Opts
+	server      *http.Server
 	listenErrCh chan error
 }
 
 func New(o *Opts) *Handler {
-	handler := &Handler{options: o}
+	handler := &Handler{options: o, server: &http.Server{Addr: o.ListenAddress}, listenErrCh: make(chan error, 1)}
 	return handler
 }
 
@@ -29,16 +31,25 @@ func (h *Handler) Run() {
 	log.Printf("Listening on %s", h.options.ListenAddress)
 
 	c := cors.New(cors.Options{
-		AllowedHeaders: []string{"X-Requested-With", "Authorization", "Content-Type", "X-Authorization"},
+		AllowedHeaders: []string{"X-Requested-With", "Authorization", "Content-Type", "X-Authorization", "X-Request-ID", "traceparent", "tracestate"},
+		ExposedHeaders: []string{"X-Request-ID"},
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"FETCH", "GET", "POST", "DELETE", "PUT", "OPTIONS"},
+		AllowedMethods: []string{"FETCH", "GET", "POST", "DELETE", "PUT", "PATCH", "OPTIONS"},
 	})
 
 	router := httprouter.New()
 	api.New(h.options.Modules).Register(router)
 
-	handler := c.Handler(router)
-	h.listenErrCh <- http.ListenAndServe(h.options.ListenAddress, handler)
+	h.server.Handler = c.Handler(router)
+	err := h.server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		h.listenErrCh <- err
+	}
+}
+
+// Shutdown stops accepting connections and waits for the running requests until ctx is done.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	return h.server.Shutdown(ctx)
 }
 
 func (h *Handler) ListenError() <-chan error {
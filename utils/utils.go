@@ -6,6 +6,12 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"github.com/hansels/sense_backend/common/log"
+	"github.com/hansels/sense_backend/config"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
 )
 
 func GenerateSHA1(o interface{}) string {
@@ -20,3 +26,75 @@ func GenerateSHA256(secret string, content string) string {
 	h.Write([]byte(content))
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+var (
+	trustedProxiesOnce sync.Once
+	trustedProxies     []*net.IPNet
+)
+
+// ClientIP returns the address of the client. Clients can send any X-Forwarded-For they like, so it is only
+// followed on requests from config.TrustedProxies, and only up to the right-most hop that is not a trusted proxy.
+func ClientIP(r *http.Request) string {
+	remote := remoteIP(r)
+	if !isTrustedProxy(net.ParseIP(remote)) {
+		return remote
+	}
+
+	var hops []string
+	for _, forwarded := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(forwarded, ",")...)
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// A trusted proxy would not have written this, whatever is left of it came from the client
+			break
+		}
+		client = ip.String()
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	trustedProxiesOnce.Do(func() {
+		for _, proxy := range config.TrustedProxies {
+			if !strings.Contains(proxy, "/") {
+				if strings.Contains(proxy, ":") {
+					proxy += "/128"
+				} else {
+					proxy += "/32"
+				}
+			}
+			_, network, err := net.ParseCIDR(proxy)
+			if err != nil {
+				log.Warnf("Ignoring trusted proxy %s : %v", proxy, err)
+				continue
+			}
+			trustedProxies = append(trustedProxies, network)
+		}
+	})
+
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}